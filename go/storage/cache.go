@@ -0,0 +1,97 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"time"
+)
+
+// volumeCacheEntry holds the result of a GetVolumes query.
+type volumeCacheEntry struct {
+	expires time.Time
+	vset    VolumeSet
+}
+
+// EnableCache caches the results of GetVolumes for ttl, so that repeated queries with the same
+// filter within ttl return the previous result without querying WMI. A ttl of zero disables the
+// cache.
+//
+// Cached volumes reflect the state at the time of the original query; call InvalidateCache after
+// modifying volumes to see their new state.
+//
+// Example:
+//		svc.EnableCache(5 * time.Second)
+func (svc *Service) EnableCache(ttl time.Duration) {
+	defer svc.lock()()
+	svc.clearCache()
+	svc.cacheTTL = ttl
+}
+
+// InvalidateCache discards all cached GetVolumes results.
+func (svc *Service) InvalidateCache() {
+	defer svc.lock()()
+	svc.clearCache()
+}
+
+// clearCache releases the cached volumes. The caller must hold the Service lock.
+func (svc *Service) clearCache() {
+	for _, e := range svc.volumeCache {
+		e.vset.Close()
+	}
+	svc.volumeCache = nil
+}
+
+// cachedVolumes returns a copy of the cached result for filter, if there is an unexpired one.
+// The caller must hold the Service lock.
+func (svc *Service) cachedVolumes(filter string) (VolumeSet, bool) {
+	e, ok := svc.volumeCache[filter]
+	if !ok {
+		return VolumeSet{}, false
+	}
+	if time.Now().After(e.expires) {
+		e.vset.Close()
+		delete(svc.volumeCache, filter)
+		return VolumeSet{}, false
+	}
+	return copyVolumeSet(e.vset), true
+}
+
+// cacheVolumes stores a copy of vset as the result for filter, if caching is enabled. The caller
+// must hold the Service lock.
+func (svc *Service) cacheVolumes(filter string, vset VolumeSet) {
+	if svc.cacheTTL <= 0 {
+		return
+	}
+	if svc.volumeCache == nil {
+		svc.volumeCache = make(map[string]volumeCacheEntry)
+	}
+	svc.volumeCache[filter] = volumeCacheEntry{
+		expires: time.Now().Add(svc.cacheTTL),
+		vset:    copyVolumeSet(vset),
+	}
+}
+
+// copyVolumeSet copies vset, taking a new reference on each volume handle so that the copy and
+// the original can be closed independently.
+func copyVolumeSet(vset VolumeSet) VolumeSet {
+	c := VolumeSet{Volumes: make([]Volume, len(vset.Volumes))}
+	copy(c.Volumes, vset.Volumes)
+	for _, v := range c.Volumes {
+		if v.handle != nil {
+			v.handle.AddRef()
+		}
+	}
+	return c
+}
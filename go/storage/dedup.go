@@ -0,0 +1,127 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/go-ole/go-ole"
+)
+
+// dedupNamespace is the WMI namespace hosting the Data Deduplication provider. It only exists once
+// the Data Deduplication feature is installed.
+const dedupNamespace = `ROOT\Microsoft\Windows\Deduplication`
+
+// Data deduplication modes, as reported in Volume.DedupMode and accepted by SetDedupMode.
+//
+// Ref: https://docs.microsoft.com/en-us/previous-versions/windows/desktop/stormgmt/msft-volume
+const (
+	DedupDisabled       int32 = 0
+	DedupGeneralPurpose int32 = 1
+	DedupHyperV         int32 = 2
+	DedupBackup         int32 = 3
+)
+
+// COM error codes returned when the Data Deduplication provider is not registered.
+const (
+	wbemEInvalidNamespace = 0x8004100E
+	wbemEInvalidClass     = 0x80041010
+)
+
+// dedupMissing translates the errors returned while the Data Deduplication feature is not installed
+// to an error matching both err and ErrDedupNotInstalled.
+func dedupMissing(err error) error {
+	var oleErr *ole.OleError
+	if !errors.As(err, &oleErr) {
+		return err
+	}
+	code := uint32(oleErr.Code())
+	// Errors raised through the scripting API are reported through the exception info.
+	if info, ok := oleErr.SubError().(ole.EXCEPINFO); ok {
+		code = info.SCODE()
+	}
+	switch code {
+	case wbemEInvalidNamespace, wbemEInvalidClass:
+		return joinErrors([]error{err, ErrDedupNotInstalled})
+	}
+	return err
+}
+
+// SetDedupMode enables data deduplication on the volume with the given mode, or disables it if mode
+// is DedupDisabled, and updates DedupMode on success.
+//
+// SetDedupMode fails with ErrDedupNotInstalled if the Data Deduplication feature is not installed. It
+// connects to the Data Deduplication provider of the local machine, so fails with ErrNotSupported for
+// volumes retrieved through a remote connection.
+//
+// Example:
+//		if _, err := v.SetDedupMode(storage.DedupGeneralPurpose); errors.Is(err, storage.ErrDedupNotInstalled) {
+//			// install the FS-Data-Deduplication feature
+//		}
+//
+// Ref: https://docs.microsoft.com/en-us/previous-versions/windows/desktop/dedup/msft-dedupvolume
+func (v *Volume) SetDedupMode(mode int32) (ExtendedStatus, error) {
+	stat := ExtendedStatus{}
+	if mode < DedupDisabled || mode > DedupBackup {
+		return stat, fmt.Errorf("SetDedupMode(%d): invalid mode", mode)
+	}
+	if err := v.svc.checkLocal(); err != nil {
+		return stat, fmt.Errorf("SetDedupMode(%d): %w", mode, err)
+	}
+	if v.svc.skipDryRun("Volume.SetDedupMode(%s, %d)", v.Path, mode) {
+		return stat, nil
+	}
+	volume := v.Path
+	if v.DriveLetter != "" {
+		volume = v.DriveLetter + ":"
+	}
+
+	dsvc, err := NewService(NamespaceDeduplication)
+	if err != nil {
+		return stat, fmt.Errorf("SetDedupMode(%d): %w", mode, dedupMissing(err))
+	}
+	defer dsvc.Close()
+	// Enable and Disable are static methods, called on the class.
+	class, err := dsvc.getObject("MSFT_DedupVolume")
+	if err != nil {
+		return stat, fmt.Errorf("SetDedupMode(%d): %w", mode, dedupMissing(err))
+	}
+	defer class.Release()
+
+	method := "Disable"
+	params := map[string]interface{}{"Volume": []string{volume}}
+	if mode != DedupDisabled {
+		method = "Enable"
+		// UsageType uses the same values as DedupMode.
+		params["UsageType"] = mode
+	}
+	out, err := v.svc.execMethod(class, method, params)
+	if err != nil {
+		return stat, fmt.Errorf("SetDedupMode(%d): %w", mode, err)
+	}
+	defer out.Release()
+	if es, err := v.svc.getProperty(out, "ExtendedStatus"); err == nil {
+		stat = v.svc.newExtendedStatus(es)
+	}
+	ret, err := v.svc.getProperty(out, "ReturnValue")
+	if err != nil {
+		return stat, fmt.Errorf("SetDedupMode(%d): %w", mode, err)
+	} else if val, ok := ret.Value().(int32); val != 0 || !ok {
+		return stat, &StorageError{"setting dedup mode", val}
+	}
+	v.DedupMode = mode
+	return stat, nil
+}
@@ -0,0 +1,46 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/go-ole/go-ole"
+)
+
+func TestDedupMissing(t *testing.T) {
+	tests := []struct {
+		desc string
+		err  error
+		want bool
+	}{
+		{"invalid namespace", ole.NewError(wbemEInvalidNamespace), true},
+		{"invalid class", fmt.Errorf("Get: %w", ole.NewError(wbemEInvalidClass)), true},
+		{"exception", exceptionError(wbemEInvalidNamespace), true},
+		{"other exception", exceptionError(0x80041013), false},
+		{"other", errors.New("access denied"), false},
+	}
+	for _, tt := range tests {
+		err := dedupMissing(tt.err)
+		if got := errors.Is(err, ErrDedupNotInstalled); got != tt.want {
+			t.Errorf("%s: errors.Is(dedupMissing(%v), ErrDedupNotInstalled) = %t, want %t", tt.desc, tt.err, got, tt.want)
+		}
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: dedupMissing(%v) = %v, which does not wrap the original error", tt.desc, tt.err, err)
+		}
+	}
+}
@@ -16,12 +16,14 @@ package storage
 
 import (
 	"fmt"
-	"reflect"
+	"math"
+	"regexp"
+	"sort"
 	"strconv"
+	"strings"
 
 	"github.com/google/logger"
 	"github.com/go-ole/go-ole"
-	"github.com/go-ole/go-ole/oleutil"
 )
 
 // Disk represents a MSFT_Disk object.
@@ -59,26 +61,96 @@ type Disk struct {
 	IsBoot             bool
 	BootFromDisk       bool
 
+	// AllowSystemDisk permits destructive operations on this disk while system disk protection is enabled.
+	AllowSystemDisk bool
+
 	handle *ole.IDispatch
+	svc    *Service
+}
+
+// checkSystemDisk returns ErrSystemDisk if system disk protection applies to the disk and the disk
+// hosts the running OS.
+func (d *Disk) checkSystemDisk() error {
+	if d.AllowSystemDisk || d.svc == nil || !enabled(d.svc.protectSystemDisk) {
+		return nil
+	}
+	if d.IsSystem || d.IsBoot {
+		return fmt.Errorf("disk %d: %w", d.Number, ErrSystemDisk)
+	}
+	return nil
+}
+
+// IsDynamic reports whether the disk is a dynamic disk, managed by the Logical Disk Manager (LDM)
+// rather than holding basic partitions. Partition operations on dynamic disks act on the LDM
+// containers, not on the volumes inside them.
+func (d *Disk) IsDynamic() (bool, error) {
+	handles, err := d.svc.associators(d.handle, "MSFT_DiskToPartition", "MSFT_Partition")
+	if err != nil {
+		return false, fmt.Errorf("IsDynamic(%d): %w", d.Number, err)
+	}
+	defer releaseAll(handles)
+
+	for _, h := range handles {
+		part := Partition{handle: h}
+		if err := part.Query(); err != nil {
+			return false, fmt.Errorf("IsDynamic(%d): %w", d.Number, err)
+		}
+		if part.isLDM() {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// warnIfDynamic logs a warning if a destructive operation is about to run against a dynamic disk.
+func (d *Disk) warnIfDynamic(op string) {
+	dynamic, err := d.IsDynamic()
+	if err != nil {
+		logger.Warningf("%s: could not determine whether disk %d is dynamic: %v", op, d.Number, err)
+		return
+	}
+	if dynamic {
+		logger.Warningf("%s: disk %d is a dynamic (LDM) disk; partition operations may not behave as on a basic disk", op, d.Number)
+	}
 }
 
 // Clear wipes a disk and all its contents.
 //
+// removeData removes data partitions, and removeOEM also removes OEM and recovery partitions, which
+// otherwise survive the wipe and prevent a clean GPT layout. zeroOutEntireDisk writes zeros to the
+// whole disk, rather than only the partition table.
+//
+// On success, the disk state is re-read, so the disk reports the RAW partition style and can be
+// passed directly to Initialize. On failure, the returned ExtendedStatus describes the cause, such as
+// the disk being in use or access being denied.
+//
 // Example:
 //		d.Clear(true, true, true)
 //
 // Ref: https://docs.microsoft.com/en-us/previous-versions/windows/desktop/stormgmt/clear-msft-disk
-func (d *Disk) Clear(removeData, removeOEM, zeroDisk bool) (ExtendedStatus, error) {
+func (d *Disk) Clear(removeData, removeOEM, zeroOutEntireDisk bool) (ExtendedStatus, error) {
+	defer d.svc.timeCall("Disk.Clear")()
 	stat := ExtendedStatus{}
+	if err := d.checkSystemDisk(); err != nil {
+		return stat, err
+	}
+	if d.svc.skipDryRun("Disk.Clear(%d, removeData=%t, removeOEM=%t, zeroOutEntireDisk=%t)", d.Number, removeData, removeOEM, zeroOutEntireDisk) {
+		return stat, nil
+	}
+	d.warnIfDynamic("Clear")
 	var extendedStatus ole.VARIANT
 	ole.VariantInit(&extendedStatus)
-	res, err := oleutil.CallMethod(d.handle, "Clear", removeData, removeOEM, zeroDisk, &extendedStatus)
+	res, err := d.svc.wmi().CallMethod(d.handle, "Clear", removeData, removeOEM, zeroOutEntireDisk, &extendedStatus)
+	stat = d.svc.newExtendedStatus(&extendedStatus)
 	if err != nil {
 		return stat, fmt.Errorf("Clear(): %w", err)
 	} else if val, ok := res.Value().(int32); val != 0 || !ok {
-		return stat, fmt.Errorf("error code returned during disk wipe: %d", val)
+		return stat, &StorageError{"disk wipe", val}
 	}
-	return stat, nil
+	if err := d.svc.refreshObject(d.handle); err != nil {
+		return stat, fmt.Errorf("Clear(): %w", err)
+	}
+	return stat, d.Query()
 }
 
 // Close releases the handle to the disk.
@@ -105,6 +177,8 @@ var MbrTypes = struct {
 	IFS MbrType
 	// FAT32 is a FAT32 partition.
 	FAT32 MbrType
+	// LDM is a Logical Disk Manager (LDM) partition on a dynamic disk.
+	LDM MbrType
 }{
 	FAT12:    1,
 	FAT16:    4,
@@ -112,6 +186,7 @@ var MbrTypes = struct {
 	Huge:     6,
 	IFS:      7,
 	FAT32:    12,
+	LDM:      66,
 }
 
 // GptType describes a GPT partition type.
@@ -140,6 +215,155 @@ var GptTypes = struct {
 	MicrosoftRecovery: "{de94bba4-06d1-4d40-a16a-bfd50179d6ac}",
 }
 
+// gptTypeAliases maps friendly names accepted by ParseGptType to their partition types.
+var gptTypeAliases = map[string]GptType{
+	"efi":         GptTypes.SystemPartition,
+	"esp":         GptTypes.SystemPartition,
+	"msr":         GptTypes.MicrosoftReserved,
+	"basicdata":   GptTypes.BasicData,
+	"ldmmetadata": GptTypes.LDMMetadata,
+	"ldmdata":     GptTypes.LDMData,
+	"windowsre":   GptTypes.MicrosoftRecovery,
+	"recovery":    GptTypes.MicrosoftRecovery,
+}
+
+var guidRe = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// ParseGptType parses a GPT partition type GUID, with or without braces, or one of the aliases
+// "EFI", "MSR", "BasicData", "LDMMetadata", "LDMData" or "WindowsRE" (case-insensitive).
+// The result is in the canonical form used by GptTypes, lowercase and enclosed in braces.
+//
+// Example:
+//		t, err := storage.ParseGptType("EFI")
+//		d.CreatePartition(100<<20, false, 0, 0, "", false, nil, &t, false, false)
+func ParseGptType(s string) (GptType, error) {
+	if t, ok := gptTypeAliases[strings.ToLower(s)]; ok {
+		return t, nil
+	}
+	guid, err := canonicalGUID(s)
+	if err != nil {
+		return "", fmt.Errorf("invalid GPT partition type %q", s)
+	}
+	return GptType(guid), nil
+}
+
+// canonicalGUID validates a GUID, with or without braces, and returns it lowercase and enclosed in
+// braces.
+func canonicalGUID(s string) (string, error) {
+	guid := s
+	if strings.HasPrefix(guid, "{") && strings.HasSuffix(guid, "}") {
+		guid = guid[1 : len(guid)-1]
+	}
+	if !guidRe.MatchString(guid) {
+		return "", fmt.Errorf("invalid GUID %q", s)
+	}
+	return "{" + strings.ToLower(guid) + "}", nil
+}
+
+// DefaultMSRSize returns the recommended size in bytes of the Microsoft Reserved (MSR) partition for a
+// GPT disk of diskSize bytes: 32MiB for disks smaller than 16GiB, and 128MiB otherwise.
+//
+// Windows 10 and later create a 16MiB MSR by default, but the larger sizes remain valid.
+//
+// Ref: https://docs.microsoft.com/en-us/windows-hardware/manufacture/desktop/windows-and-gpt-faq
+func DefaultMSRSize(diskSize uint64) uint64 {
+	if diskSize < 16<<30 {
+		return 32 << 20
+	}
+	return 128 << 20
+}
+
+// mbr2gptMaxPartitions is the most partitions MBR2GPT can convert, leaving room for the EFI system
+// partition it creates.
+const mbr2gptMaxPartitions = 3
+
+// ConvertToGPT converts the disk from the MBR to the GPT partition style.
+//
+// Empty disks are converted through the storage provider. Disks holding partitions are converted in
+// place with MBR2GPT, which preserves their data and creates an EFI system partition; the disk must
+// hold at most three primary partitions, and the conversion is validated before any change is made.
+// MBR2GPT only converts disks holding an installed Windows OS, and its validation fails for data
+// disks, which must be cleared and converted while empty instead.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/deployment/mbr-to-gpt
+func (d *Disk) ConvertToGPT() (ExtendedStatus, error) {
+	stat := ExtendedStatus{}
+	if d.Style() == GptStyle {
+		return stat, fmt.Errorf("ConvertToGPT: disk %d is already GPT", d.Number)
+	}
+	if d.Style() != MbrStyle {
+		return stat, fmt.Errorf("ConvertToGPT: disk %d is not initialized", d.Number)
+	}
+	if err := d.checkSystemDisk(); err != nil {
+		return stat, err
+	}
+	if d.svc.skipDryRun("Disk.ConvertToGPT(%d)", d.Number) {
+		return stat, nil
+	}
+	if d.NumberOfPartitions == 0 {
+		return d.convertStyle(GptStyle)
+	}
+	if d.NumberOfPartitions > mbr2gptMaxPartitions {
+		return stat, fmt.Errorf("ConvertToGPT: disk %d has %d partitions, at most %d can be converted",
+			d.Number, d.NumberOfPartitions, mbr2gptMaxPartitions)
+	}
+
+	if err := d.svc.checkLocal(); err != nil {
+		return stat, fmt.Errorf("ConvertToGPT: %w", err)
+	}
+	disk := fmt.Sprintf("/disk:%d", d.Number)
+	for _, mode := range []string{"/validate", "/convert"} {
+		if _, err := fnExec(mbr2gptExe, []string{mode, disk, "/allowFullOS"}, nil); err != nil {
+			return stat, fmt.Errorf("ConvertToGPT: mbr2gpt %s: %w", mode, err)
+		}
+	}
+	if err := d.svc.refreshObject(d.handle); err != nil {
+		return stat, fmt.Errorf("ConvertToGPT: %w", err)
+	}
+	return stat, d.Query()
+}
+
+// ConvertToMBR converts the disk from the GPT to the MBR partition style. Only disks without
+// partitions can be converted.
+func (d *Disk) ConvertToMBR() (ExtendedStatus, error) {
+	stat := ExtendedStatus{}
+	if d.Style() == MbrStyle {
+		return stat, fmt.Errorf("ConvertToMBR: disk %d is already MBR", d.Number)
+	}
+	if d.NumberOfPartitions != 0 {
+		return stat, fmt.Errorf("ConvertToMBR: disk %d has %d partitions, only empty disks can be converted",
+			d.Number, d.NumberOfPartitions)
+	}
+	if err := d.checkSystemDisk(); err != nil {
+		return stat, err
+	}
+	if d.svc.skipDryRun("Disk.ConvertToMBR(%d)", d.Number) {
+		return stat, nil
+	}
+	return d.convertStyle(MbrStyle)
+}
+
+// convertStyle converts the partition style of an empty, initialized disk.
+//
+// Ref: https://docs.microsoft.com/en-us/previous-versions/windows/desktop/stormgmt/msft-disk-convertstyle
+func (d *Disk) convertStyle(ps PartitionStyle) (ExtendedStatus, error) {
+	stat := ExtendedStatus{}
+	d.warnIfDynamic("ConvertStyle")
+	var extendedStatus ole.VARIANT
+	ole.VariantInit(&extendedStatus)
+	res, err := d.svc.wmi().CallMethod(d.handle, "ConvertStyle", int32(ps), &extendedStatus)
+	stat = d.svc.newExtendedStatus(&extendedStatus)
+	if err != nil {
+		return stat, fmt.Errorf("ConvertStyle(%d): %w", ps, err)
+	} else if val, ok := res.Value().(int32); val != 0 || !ok {
+		return stat, &StorageError{"partition style conversion", val}
+	}
+	if err := d.svc.refreshObject(d.handle); err != nil {
+		return stat, fmt.Errorf("ConvertStyle(%d): %w", ps, err)
+	}
+	return stat, d.Query()
+}
+
 // CreatePartition creates a partition on a disk.
 //
 // If successful, the partition is returned as a new Partition object. The new Partition must be Closed().
@@ -147,12 +371,15 @@ var GptTypes = struct {
 // Creating a GPT Basic Data partition, 100000000b size, drive letter "e:":
 //		d.CreatePartition(100000000, false, 0, 0, "e", false, nil, &storage.GptTypes.BasicData, false, false)
 //
+// When assignDriveLetter is set, the returned Partition's DriveLetter holds the letter chosen by the system.
+//
 // Creating an MBR FAT32 partition, full available space, marked active, with auto-assigned drive letter:
 // 		CreatePartition(0, true, 0, 0, "", true, &storage.MbrTypes.FAT32, nil, false, true)
 //
 // Ref: https://docs.microsoft.com/en-us/previous-versions/windows/desktop/stormgmt/createpartition-msft-disk
 func (d *Disk) CreatePartition(size int, useMaximumSize bool, offset int, alignment int, driveLetter string, assignDriveLetter bool,
 	mbrType *MbrType, gptType *GptType, hidden, active bool) (Partition, ExtendedStatus, error) {
+	defer d.svc.timeCall("Disk.CreatePartition")()
 	part := Partition{}
 	stat := ExtendedStatus{}
 
@@ -165,6 +392,10 @@ func (d *Disk) CreatePartition(size int, useMaximumSize bool, offset int, alignm
 	if mbrType != nil && gptType != nil {
 		return part, stat, fmt.Errorf("cannot specify both gpt and mbr partition types")
 	}
+	if d.svc.skipDryRun("Disk.CreatePartition(%d, size=%d, useMaximumSize=%t)", d.Number, size, useMaximumSize) {
+		return part, stat, nil
+	}
+	d.warnIfDynamic("CreatePartition")
 
 	// Several parameters have to be nil in cases where they're meant to use defaults, or where they're excluded by other options.
 	var ialignment interface{}
@@ -209,14 +440,22 @@ func (d *Disk) CreatePartition(size int, useMaximumSize bool, offset int, alignm
 	ole.VariantInit(&createdPartition)
 	var extendedStatus ole.VARIANT
 	ole.VariantInit(&extendedStatus)
-	res, err := oleutil.CallMethod(d.handle, "CreatePartition", isize, useMaximumSize, ioffset, ialignment, iletter, assignDriveLetter, imbr, igpt, hidden, active, &createdPartition, &extendedStatus)
+	res, err := d.svc.wmi().CallMethod(d.handle, "CreatePartition", isize, useMaximumSize, ioffset, ialignment, iletter, assignDriveLetter, imbr, igpt, hidden, active, &createdPartition, &extendedStatus)
+	stat = d.svc.newExtendedStatus(&extendedStatus)
 	if err != nil {
 		return part, stat, fmt.Errorf("CreatePartition(): %w", err)
 	} else if val, ok := res.Value().(int32); val != 0 || !ok {
-		return part, stat, fmt.Errorf("error code returned during partition creation: %d", val)
+		return part, stat, &StorageError{"partition creation", val}
 	}
 
 	part.handle = createdPartition.ToIDispatch()
+	part.svc = d.svc
+	if assignDriveLetter {
+		// The returned object predates the drive letter assignment; re-read it so DriveLetter is populated.
+		if err := d.svc.refreshObject(part.handle); err != nil {
+			return part, stat, err
+		}
+	}
 	return part, stat, part.Query()
 }
 
@@ -224,28 +463,316 @@ func (d *Disk) CreatePartition(size int, useMaximumSize bool, offset int, alignm
 type PartitionStyle int32
 
 const (
+	// RawStyle represents a disk which has not been initialized with a partition style.
+	RawStyle PartitionStyle = 0
 	// MbrStyle represents the MBR partition style for a disk.
 	MbrStyle PartitionStyle = 1
 	// GptStyle represents the GPT partition style for a disk.
 	GptStyle PartitionStyle = 2
 )
 
-// Initialize initializes a new disk.
+// PartitionStyleMBR and PartitionStyleGPT are the partition styles accepted by Initialize.
+const (
+	PartitionStyleMBR = MbrStyle
+	PartitionStyleGPT = GptStyle
+)
+
+// String returns the name of the partition style.
+func (ps PartitionStyle) String() string {
+	switch ps {
+	case RawStyle:
+		return "RAW"
+	case MbrStyle:
+		return "MBR"
+	case GptStyle:
+		return "GPT"
+	}
+	return fmt.Sprintf("Unknown(%d)", int32(ps))
+}
+
+// Style returns the partition style of the disk.
+func (d *Disk) Style() PartitionStyle {
+	return PartitionStyle(d.PartitionStyle)
+}
+
+// IsRaw reports whether the disk is uninitialized, and must be initialized before it can be partitioned.
+//
+// Example:
+//		if d.IsRaw() {
+//			d.Initialize(storage.GptStyle)
+//		}
+func (d *Disk) IsRaw() bool {
+	return d.Style() == RawStyle
+}
+
+// BusType represents the type of bus a disk is attached through.
+//
+// Ref: https://docs.microsoft.com/en-us/previous-versions/windows/desktop/stormgmt/msft-disk
+type BusType int32
+
+// Known bus types.
+const (
+	BusTypeUnknown           BusType = 0
+	BusTypeSCSI              BusType = 1
+	BusTypeATAPI             BusType = 2
+	BusTypeATA               BusType = 3
+	BusType1394              BusType = 4
+	BusTypeSSA               BusType = 5
+	BusTypeFibreChannel      BusType = 6
+	BusTypeUSB               BusType = 7
+	BusTypeRAID              BusType = 8
+	BusTypeISCSI             BusType = 9
+	BusTypeSAS               BusType = 10
+	BusTypeSATA              BusType = 11
+	BusTypeSD                BusType = 12
+	BusTypeMMC               BusType = 13
+	BusTypeVirtual           BusType = 14
+	BusTypeFileBackedVirtual BusType = 15
+	BusTypeSpaces            BusType = 16
+	BusTypeNVMe              BusType = 17
+	BusTypeSCM               BusType = 18
+	BusTypeUFS               BusType = 19
+)
+
+// busTypeNames maps bus types to their names.
+var busTypeNames = map[int32]string{
+	0:  "Unknown",
+	1:  "SCSI",
+	2:  "ATAPI",
+	3:  "ATA",
+	4:  "1394",
+	5:  "SSA",
+	6:  "FibreChannel",
+	7:  "USB",
+	8:  "RAID",
+	9:  "iSCSI",
+	10: "SAS",
+	11: "SATA",
+	12: "SD",
+	13: "MMC",
+	14: "Virtual",
+	15: "FileBackedVirtual",
+	16: "Spaces",
+	17: "NVMe",
+	18: "SCM",
+	19: "UFS",
+}
+
+// String returns the name of the bus type.
+func (bt BusType) String() string {
+	return enumName(busTypeNames, int32(bt))
+}
+
+// Bus returns the type of bus the disk is attached through.
+func (d *Disk) Bus() BusType {
+	return BusType(d.BusType)
+}
+
+// An Extent describes a contiguous region of a disk, in bytes.
+type Extent struct {
+	Offset uint64
+	Size   uint64
+}
+
+// extentAlignment is the alignment Windows uses for partition offsets.
+const extentAlignment = 1 << 20
+
+// gptReservedBytes is the space occupied by the GPT header and partition entry array at each end of
+// the disk, excluding the header sector itself.
+const gptReservedBytes = 16384
+
+// GetFreeExtents returns the unallocated regions of the disk, in ascending order of offset.
+//
+// Extents are aligned to 1MiB and exclude the areas reserved for the partition table, so their
+// Offset and Size can be passed directly to CreatePartition.
+//
+// Example:
+//		extents, err := d.GetFreeExtents()
+//		e := extents[0]
+//		d.CreatePartition(int(e.Size), false, int(e.Offset), 0, "", true, nil, &storage.GptTypes.BasicData, false, false)
+func (d *Disk) GetFreeExtents() ([]Extent, error) {
+	handles, err := d.svc.associators(d.handle, "MSFT_DiskToPartition", "MSFT_Partition")
+	if err != nil {
+		return nil, fmt.Errorf("GetFreeExtents(%d): %w", d.Number, err)
+	}
+	defer releaseAll(handles)
+
+	var used []Extent
+	for _, h := range handles {
+		part := Partition{handle: h}
+		if err := part.Query(); err != nil {
+			return nil, fmt.Errorf("GetFreeExtents(%d): %w", d.Number, err)
+		}
+		used = append(used, Extent{Offset: part.Offset, Size: part.Size})
+	}
+	return freeExtents(d.Size, uint64(d.LogicalSectorSize), d.Style(), used), nil
+}
+
+// freeExtents computes the aligned free regions of a disk of diskSize bytes, given the extents
+// already in use.
+func freeExtents(diskSize, sectorSize uint64, style PartitionStyle, used []Extent) []Extent {
+	if sectorSize == 0 {
+		sectorSize = 512
+	}
+	// The MBR, or the protective MBR and GPT header, occupy the first sectors of the disk.
+	start, end := sectorSize, diskSize
+	if style == GptStyle {
+		start = 2*sectorSize + gptReservedBytes
+		// The backup GPT header and partition entry array occupy the end of the disk.
+		end = diskSize - sectorSize - gptReservedBytes
+	}
+
+	sort.Slice(used, func(i, j int) bool { return used[i].Offset < used[j].Offset })
+
+	var free []Extent
+	addFree := func(from, to uint64) {
+		from = (from + extentAlignment - 1) / extentAlignment * extentAlignment
+		to = to / extentAlignment * extentAlignment
+		if to > from {
+			free = append(free, Extent{Offset: from, Size: to - from})
+		}
+	}
+	cur := start
+	for _, e := range used {
+		if e.Offset > cur {
+			addFree(cur, e.Offset)
+		}
+		if e.Offset+e.Size > cur {
+			cur = e.Offset + e.Size
+		}
+	}
+	if end > cur {
+		addFree(cur, end)
+	}
+	return free
+}
+
+// WriteCacheEnabled reports whether the disk's write cache is enabled. It returns ErrNotSupported if
+// the disk does not report its cache settings.
+func (d *Disk) WriteCacheEnabled() (bool, error) {
+	if err := d.svc.checkLocal(); err != nil {
+		return false, fmt.Errorf("WriteCacheEnabled: %w", err)
+	}
+	enabled, err := writeCacheEnabled(d.Number)
+	if err != nil {
+		return false, fmt.Errorf("WriteCacheEnabled: %w", err)
+	}
+	return enabled, nil
+}
+
+// Recommended actions returned by HealthActions.
+const (
+	HealthActionNone      = "none"
+	HealthActionRetire    = "retire"
+	HealthActionReplace   = "replace"
+	HealthActionReconnect = "reconnect"
+	HealthActionOnline    = "online"
+)
+
+// HealthActions refreshes the disk's health and operational status, and returns the actions
+// recommended to an operator:
+//
+//		retire:    the disk predicts a failure; move data off it
+//		replace:   the disk has failed or reports unrecoverable errors
+//		reconnect: the disk cannot be reached
+//		online:    the disk is offline
+//		none:      the disk is healthy
+func (d *Disk) HealthActions() ([]string, error) {
+	if err := d.svc.refreshObject(d.handle); err != nil {
+		return nil, fmt.Errorf("HealthActions: %w", err)
+	}
+	if err := d.Query(); err != nil {
+		return nil, fmt.Errorf("HealthActions: %w", err)
+	}
+	p, err := d.svc.wmi().GetProperty(d.handle, "OperationalStatus")
+	if err != nil {
+		return nil, fmt.Errorf("oleutil.GetProperty(OperationalStatus): %w", err)
+	}
+	return healthActions(d.HealthStatus, int32Array(p)), nil
+}
+
+// int32Array converts an array property, such as OperationalStatus, to a slice of int32 values.
+func int32Array(p *ole.VARIANT) []int32 {
+	var vals []int32
+	if arr := p.ToArray(); arr != nil {
+		for _, s := range arr.ToValueArray() {
+			switch v := s.(type) {
+			case int32:
+				vals = append(vals, v)
+			case uint16:
+				vals = append(vals, int32(v))
+			}
+		}
+	}
+	return vals
+}
+
+// healthActions maps a disk's HealthStatus and OperationalStatus values to recommended actions.
+func healthActions(health int32, opStatus []int32) []string {
+	var actions []string
+	add := func(a string) {
+		for _, x := range actions {
+			if x == a {
+				return
+			}
+		}
+		actions = append(actions, a)
+	}
+	for _, s := range opStatus {
+		switch s {
+		case 5: // Predictive Failure
+			add(HealthActionRetire)
+		case 6, 7, 0xD014: // Error, Non-Recoverable Error, Failed
+			add(HealthActionReplace)
+		case 12, 13: // No Contact, Lost Communication
+			add(HealthActionReconnect)
+		case 0xD013: // Offline
+			add(HealthActionOnline)
+		}
+	}
+	if len(actions) == 0 {
+		switch health {
+		case 1: // Warning
+			add(HealthActionRetire)
+		case 2: // Unhealthy
+			add(HealthActionReplace)
+		default:
+			add(HealthActionNone)
+		}
+	}
+	return actions
+}
+
+// diskAlreadyInitialized is returned by MSFT_Disk.Initialize when the disk already has a partition style.
+const diskAlreadyInitialized = 41001
+
+// Initialize initializes a new disk. It returns ErrDiskAlreadyInitialized if the disk is not raw.
 //
 // Example:
-//		d.Initialize(storage.GptStyle)
+//		d.Initialize(storage.PartitionStyleGPT)
 //
 // Ref: https://docs.microsoft.com/en-us/previous-versions/windows/desktop/stormgmt/initialize-msft-disk
 func (d *Disk) Initialize(ps PartitionStyle) (ExtendedStatus, error) {
+	defer d.svc.timeCall("Disk.Initialize")()
 	stat := ExtendedStatus{}
+	if err := d.checkSystemDisk(); err != nil {
+		return stat, err
+	}
+	if d.svc.skipDryRun("Disk.Initialize(%d, %d)", d.Number, ps) {
+		return stat, nil
+	}
 	var extendedStatus ole.VARIANT
 	ole.VariantInit(&extendedStatus)
-	res, err := oleutil.CallMethod(d.handle, "Initialize", int32(ps), &extendedStatus)
+	res, err := d.svc.wmi().CallMethod(d.handle, "Initialize", int32(ps), &extendedStatus)
+	stat = d.svc.newExtendedStatus(&extendedStatus)
 	if err != nil {
 		return stat, fmt.Errorf("Initialize(%d): %w", ps, err)
-	} else if val, ok := res.Value().(int32); val != 0 || !ok {
-		return stat, fmt.Errorf("error code returned during initialization: %d", val)
+	} else if val, ok := res.Value().(int32); val == diskAlreadyInitialized {
+		return stat, fmt.Errorf("Initialize(%d): disk %d: %w", ps, d.Number, ErrDiskAlreadyInitialized)
+	} else if val != 0 || !ok {
+		return stat, &StorageError{"initialization", val}
 	}
+	d.PartitionStyle = int32(ps)
 	return stat, nil
 }
 
@@ -257,32 +784,92 @@ func (d *Disk) Initialize(ps PartitionStyle) (ExtendedStatus, error) {
 // Ref: https://docs.microsoft.com/en-us/previous-versions/windows/desktop/stormgmt/msft-disk-offline
 func (d *Disk) Offline() (ExtendedStatus, error) {
 	stat := ExtendedStatus{}
+	if d.svc.skipDryRun("Disk.Offline(%d)", d.Number) {
+		return stat, nil
+	}
 	var extendedStatus ole.VARIANT
 	ole.VariantInit(&extendedStatus)
-	res, err := oleutil.CallMethod(d.handle, "Offline", &extendedStatus)
+	res, err := d.svc.wmi().CallMethod(d.handle, "Offline", &extendedStatus)
+	stat = d.svc.newExtendedStatus(&extendedStatus)
 	if err != nil {
 		return stat, fmt.Errorf("Offline(): %w", err)
 	} else if val, ok := res.Value().(int32); val != 0 || !ok {
-		return stat, fmt.Errorf("error code returned during offline: %d", val)
+		return stat, &StorageError{"offline", val}
 	}
+	d.IsOffline = true
 	return stat, nil
 }
 
+// offlineReasonPolicy is the MSFT_Disk OfflineReason of a disk kept offline by the SAN policy.
+const offlineReasonPolicy = 1
+
 // Online brings the disk online.
 //
+// If the disk is kept offline by the SAN policy, Online fails with ErrDiskPolicyOffline.
+//
 // Example:
-//		d.Online()
+//		if _, err := d.Online(); errors.Is(err, storage.ErrDiskPolicyOffline) {
+//			// change the SAN policy rather than retrying
+//		}
 //
 // Ref: https://docs.microsoft.com/en-us/previous-versions/windows/desktop/stormgmt/msft-disk-online
 func (d *Disk) Online() (ExtendedStatus, error) {
 	stat := ExtendedStatus{}
 	var extendedStatus ole.VARIANT
 	ole.VariantInit(&extendedStatus)
-	res, err := oleutil.CallMethod(d.handle, "Online", &extendedStatus)
+	res, err := d.svc.wmi().CallMethod(d.handle, "Online", &extendedStatus)
+	stat = d.svc.newExtendedStatus(&extendedStatus)
 	if err != nil {
 		return stat, fmt.Errorf("Online(): %w", err)
 	} else if val, ok := res.Value().(int32); val != 0 || !ok {
-		return stat, fmt.Errorf("error code returned during online: %d", val)
+		if d.offlineByPolicy() {
+			return stat, fmt.Errorf("Online(): disk %d: %w", d.Number, ErrDiskPolicyOffline)
+		}
+		return stat, &StorageError{"online", val}
+	}
+	d.IsOffline = false
+	return stat, nil
+}
+
+// offlineByPolicy re-reads the disk state, and reports whether the disk is kept offline by the SAN policy.
+func (d *Disk) offlineByPolicy() bool {
+	if err := d.svc.refreshObject(d.handle); err != nil {
+		logger.Warningf("offlineByPolicy: %v", err)
+		return false
+	}
+	if err := d.Query(); err != nil {
+		logger.Warningf("offlineByPolicy: %v", err)
+		return false
+	}
+	return d.IsOffline && d.OfflineReason == offlineReasonPolicy
+}
+
+// SetAttributes changes the read-only and offline state of the disk. Attributes passed as nil are
+// left unchanged. The offline state is applied through Online or Offline, and is applied after the
+// read-only flag, so a disk can be taken offline and made read-only in a single call.
+//
+// Example:
+//		readOnly, offline := false, false
+//		d.SetAttributes(&readOnly, &offline)
+//
+// Ref: https://docs.microsoft.com/en-us/previous-versions/windows/desktop/stormgmt/msft-disk-setattributes
+func (d *Disk) SetAttributes(isReadOnly, isOffline *bool) (ExtendedStatus, error) {
+	stat := ExtendedStatus{}
+	if d.svc.skipDryRun("Disk.SetAttributes(%d)", d.Number) {
+		return stat, nil
+	}
+	if isReadOnly != nil {
+		var err error
+		if stat, err = d.setAttributes(*isReadOnly, nil, nil); err != nil {
+			return stat, err
+		}
+		d.IsReadOnly = *isReadOnly
+	}
+	if isOffline != nil {
+		if *isOffline {
+			return d.Offline()
+		}
+		return d.Online()
 	}
 	return stat, nil
 }
@@ -297,81 +884,166 @@ func (d *Disk) Refresh() (ExtendedStatus, error) {
 	stat := ExtendedStatus{}
 	var extendedStatus ole.VARIANT
 	ole.VariantInit(&extendedStatus)
-	res, err := oleutil.CallMethod(d.handle, "Refresh", &extendedStatus)
+	res, err := d.svc.wmi().CallMethod(d.handle, "Refresh", &extendedStatus)
+	stat = d.svc.newExtendedStatus(&extendedStatus)
 	if err != nil {
 		return stat, fmt.Errorf("Refresh(): %w", err)
 	} else if val, ok := res.Value().(int32); val != 0 || !ok {
-		return stat, fmt.Errorf("error code returned during refresh: %d", val)
+		return stat, &StorageError{"refresh", val}
+	}
+	return stat, nil
+}
+
+// SetGUID sets the GUID of a GPT disk. The GUID may be given with or without braces, and an error
+// wrapping ErrInvalidParameter is returned if it is malformed. GUID is set to the canonical form.
+//
+// Changing the identity of the disk hosting the running OS breaks the boot configuration referencing
+// it, so SetGUID refuses, like Clear, while system disk protection is enabled.
+//
+// Example:
+//		d.SetGUID("{6f2e6c1b-4c5d-4a3b-9c8e-1a2b3c4d5e6f}")
+//
+// Ref: https://docs.microsoft.com/en-us/previous-versions/windows/desktop/stormgmt/setattributes-msft-disk
+func (d *Disk) SetGUID(guid string) (ExtendedStatus, error) {
+	g, err := canonicalGUID(guid)
+	if err != nil {
+		return ExtendedStatus{}, fmt.Errorf("SetGUID: %v: %w", err, ErrInvalidParameter)
+	}
+	if d.Style() != GptStyle {
+		return ExtendedStatus{}, fmt.Errorf("SetGUID: disk %d is not a GPT disk", d.Number)
+	}
+	if err := d.checkSystemDisk(); err != nil {
+		return ExtendedStatus{}, fmt.Errorf("SetGUID(%s): %w", g, err)
+	}
+	stat, err := d.setAttributes(nil, nil, g)
+	if err != nil {
+		return stat, fmt.Errorf("SetGUID(%s): %w", g, err)
+	}
+	d.GUID = g
+	return stat, nil
+}
+
+// SetSignature sets the signature of an MBR disk. Like SetGUID, it refuses to change the disk hosting
+// the running OS while system disk protection is enabled.
+//
+// Example:
+//		d.SetSignature(0x1234abcd)
+//
+// Ref: https://docs.microsoft.com/en-us/previous-versions/windows/desktop/stormgmt/setattributes-msft-disk
+func (d *Disk) SetSignature(sig uint32) (ExtendedStatus, error) {
+	if d.Style() != MbrStyle {
+		return ExtendedStatus{}, fmt.Errorf("SetSignature: disk %d is not an MBR disk", d.Number)
+	}
+	if err := d.checkSystemDisk(); err != nil {
+		return ExtendedStatus{}, fmt.Errorf("SetSignature(%#x): %w", sig, err)
+	}
+	stat, err := d.setAttributes(nil, int32(sig), nil)
+	if err != nil {
+		return stat, fmt.Errorf("SetSignature(%#x): %w", sig, err)
+	}
+	d.Signature = int32(sig)
+	return stat, nil
+}
+
+// SetWriteCacheEnabled enables or disables the disk's write cache. It returns ErrNotSupported if
+// the disk does not allow its cache settings to be changed.
+//
+// Example:
+//		if err := d.SetWriteCacheEnabled(false); errors.Is(err, storage.ErrNotSupported) {
+//			logger.Warningf("disk %d does not support disabling the write cache", d.Number)
+//		}
+func (d *Disk) SetWriteCacheEnabled(enable bool) error {
+	if err := d.svc.checkLocal(); err != nil {
+		return fmt.Errorf("SetWriteCacheEnabled: %w", err)
+	}
+	if err := setWriteCacheEnabled(d.Number, enable); err != nil {
+		return fmt.Errorf("SetWriteCacheEnabled(%t): %w", enable, err)
+	}
+	return nil
+}
+
+// setAttributes calls SetAttributes on the disk. Parameters left nil are not changed.
+func (d *Disk) setAttributes(isReadOnly, signature, guid interface{}) (ExtendedStatus, error) {
+	stat := ExtendedStatus{}
+	var extendedStatus ole.VARIANT
+	ole.VariantInit(&extendedStatus)
+	res, err := d.svc.wmi().CallMethod(d.handle, "SetAttributes", isReadOnly, signature, guid, &extendedStatus)
+	stat = d.svc.newExtendedStatus(&extendedStatus)
+	if err != nil {
+		return stat, fmt.Errorf("SetAttributes(): %w", err)
+	} else if val, ok := res.Value().(int32); val != 0 || !ok {
+		return stat, &StorageError{"set attributes", val}
 	}
 	return stat, nil
 }
 
 // Query reads and populates the disk state.
 func (d *Disk) Query() error {
+	defer d.svc.timeCall("Disk.Query")()
 	if d.handle == nil {
 		return fmt.Errorf("invalid handle")
 	}
 
 	// Path
-	p, err := oleutil.GetProperty(d.handle, "Path")
+	p, err := d.svc.getProperty(d.handle, "Path")
 	if err != nil {
-		return fmt.Errorf("oleutil.GetProperty(Path): %w", err)
+		return err
 	}
 	d.Path = p.ToString()
 
 	// Location
-	p, err = oleutil.GetProperty(d.handle, "Location")
+	p, err = d.svc.getProperty(d.handle, "Location")
 	if err != nil {
-		return fmt.Errorf("oleutil.GetProperty(Location): %w", err)
+		return err
 	}
 	d.Location = p.ToString()
 
 	// FriendlyName
-	p, err = oleutil.GetProperty(d.handle, "FriendlyName")
+	p, err = d.svc.getProperty(d.handle, "FriendlyName")
 	if err != nil {
-		return fmt.Errorf("oleutil.GetProperty(FriendlyName): %w", err)
+		return err
 	}
 	d.FriendlyName = p.ToString()
 
 	// UniqueID
-	p, err = oleutil.GetProperty(d.handle, "UniqueId")
+	p, err = d.svc.getProperty(d.handle, "UniqueId")
 	if err != nil {
-		return fmt.Errorf("oleutil.GetProperty(UniqueId): %w", err)
+		return err
 	}
 	d.UniqueID = p.ToString()
 
 	// SerialNumber
-	p, err = oleutil.GetProperty(d.handle, "SerialNumber")
+	p, err = d.svc.getProperty(d.handle, "SerialNumber")
 	if err != nil {
-		return fmt.Errorf("oleutil.GetProperty(SerialNumber): %w", err)
+		return err
 	}
 	d.SerialNumber = p.ToString()
 
 	// FirmwareVersion
-	p, err = oleutil.GetProperty(d.handle, "FirmwareVersion")
+	p, err = d.svc.getProperty(d.handle, "FirmwareVersion")
 	if err != nil {
-		return fmt.Errorf("oleutil.GetProperty(FirmwareVersion): %w", err)
+		return err
 	}
 	d.FirmwareVersion = p.ToString()
 
 	// Manufacturer
-	p, err = oleutil.GetProperty(d.handle, "Manufacturer")
+	p, err = d.svc.getProperty(d.handle, "Manufacturer")
 	if err != nil {
-		return fmt.Errorf("oleutil.GetProperty(Manufacturer): %w", err)
+		return err
 	}
 	d.Manufacturer = p.ToString()
 
 	// Model
-	p, err = oleutil.GetProperty(d.handle, "Model")
+	p, err = d.svc.getProperty(d.handle, "Model")
 	if err != nil {
-		return fmt.Errorf("oleutil.GetProperty(Model): %w", err)
+		return err
 	}
 	d.Model = p.ToString()
 
 	// GUID
-	p, err = oleutil.GetProperty(d.handle, "Guid")
+	p, err = d.svc.getProperty(d.handle, "Guid")
 	if err != nil {
-		return fmt.Errorf("oleutil.GetProperty(Guid): %w", err)
+		return err
 	}
 	d.GUID = p.ToString()
 
@@ -399,9 +1071,9 @@ func (d *Disk) Query() error {
 		[]interface{}{"IsBoot", &d.IsBoot},
 		[]interface{}{"BootFromDisk", &d.BootFromDisk},
 	} {
-		prop, err := oleutil.GetProperty(d.handle, p[0].(string))
+		prop, err := d.svc.getProperty(d.handle, p[0].(string))
 		if err != nil {
-			return fmt.Errorf("oleutil.GetProperty(%s): %w", p[0].(string), err)
+			return err
 		}
 		if err := assignVariant(prop.Value(), p[1]); err != nil {
 			logger.Warningf("assignVariant(%s): %v", p[0].(string), err)
@@ -422,43 +1094,162 @@ func (s *DiskSet) Close() {
 	}
 }
 
+// FilterByBusType returns the disks in the set attached through one of the given bus types.
+//
+// The resulting DiskSet holds its own references to the disks, and must be closed independently
+// of the original.
+//
+// Example: select internal disks, excluding USB media
+//		targets := dset.FilterByBusType(storage.BusTypeSATA, storage.BusTypeNVMe)
+//		defer targets.Close()
+func (s *DiskSet) FilterByBusType(bt ...BusType) DiskSet {
+	filtered := DiskSet{}
+	for _, d := range s.Disks {
+		for _, t := range bt {
+			if d.Bus() == t {
+				if d.handle != nil {
+					d.handle.AddRef()
+				}
+				filtered.Disks = append(filtered.Disks, d)
+				break
+			}
+		}
+	}
+	return filtered
+}
+
+// removableBusTypes holds the bus types of external and removable media, which are never
+// selected by FirstFixed.
+var removableBusTypes = map[BusType]bool{
+	BusType1394:              true,
+	BusTypeUSB:               true,
+	BusTypeSD:                true,
+	BusTypeMMC:               true,
+	BusTypeFileBackedVirtual: true,
+}
+
+// FirstFixed returns the lowest numbered disk in the set which is not attached through a removable
+// bus, such as USB or SD, or backed by a virtual disk file. It returns an error wrapping ErrNotFound
+// if the set holds no such disk.
+//
+// The resulting Disk holds its own reference, and must be closed independently of the set.
+//
+// Example: select the internal disk to image, ignoring USB install media
+//		d, err := dset.FirstFixed()
+//		if err != nil {
+//			return err
+//		}
+//		defer d.Close()
+func (s *DiskSet) FirstFixed() (Disk, error) {
+	found := -1
+	for i, d := range s.Disks {
+		if removableBusTypes[d.Bus()] {
+			continue
+		}
+		if found < 0 || d.Number < s.Disks[found].Number {
+			found = i
+		}
+	}
+	if found < 0 {
+		return Disk{}, fmt.Errorf("FirstFixed: no fixed disk in set: %w", ErrNotFound)
+	}
+	d := s.Disks[found]
+	if d.handle != nil {
+		d.handle.AddRef()
+	}
+	return d, nil
+}
+
 // assignVariant attempts to assign an ole variant to a variable, while somewhat
 // gracefully handling the various type-related shenanigans involved
 func assignVariant(value interface{}, dest interface{}) error {
 	// the property is nil; leave nil value in place
-	srcType := reflect.TypeOf(value)
-	if srcType == nil {
+	if value == nil {
 		return nil
 	}
 
-	dKind := reflect.TypeOf(dest).Elem().Kind()
-
-	// avoid a panic on type mismatch
-	if srcType.Kind() != dKind {
-		if dKind == reflect.Uint64 && srcType.Kind() == reflect.String {
-			// uint64 starts out as string
-		} else {
-			return fmt.Errorf("ignoring property value %v due to type mismatch (got: %v, want: %v)", value, srcType, dKind)
+	switch d := dest.(type) {
+	case *bool:
+		b, ok := value.(bool)
+		if !ok {
+			return fmt.Errorf("ignoring property value %v due to type mismatch (got: %T, want: bool)", value, value)
+		}
+		*d = b
+	case *string:
+		str, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("ignoring property value %v due to type mismatch (got: %T, want: string)", value, value)
+		}
+		*d = str
+	case *int32:
+		n, err := variantInt(value, math.MinInt32, math.MaxInt32)
+		if err != nil {
+			return err
+		}
+		*d = int32(n)
+	case *uint8:
+		n, err := variantInt(value, 0, math.MaxUint8)
+		if err != nil {
+			return err
+		}
+		*d = uint8(n)
+	case *uint64:
+		// uint64 properties are returned as strings by the scripting API, as VARIANTs cannot hold
+		// them, but some providers return sizes as numbers.
+		if str, ok := value.(string); ok {
+			u, err := strconv.ParseUint(str, 10, 64)
+			if err != nil {
+				return fmt.Errorf("strconv.ParseUint(%v): %w", value, err)
+			}
+			*d = u
+			return nil
+		}
+		if u, ok := value.(uint64); ok {
+			*d = u
+			return nil
 		}
+		n, err := variantInt(value, 0, math.MaxInt64)
+		if err != nil {
+			return err
+		}
+		*d = uint64(n)
+	default:
+		return fmt.Errorf("unknown type for %v: %T", value, dest)
 	}
+	return nil
+}
 
-	// attempt to cast to the desired type, and assign to the variable
-	switch dKind {
-	case reflect.Bool:
-		*dest.(*bool) = value.(bool)
-	case reflect.Int32:
-		*dest.(*int32) = value.(int32)
-	case reflect.String:
-		*dest.(*string) = value.(string)
-	case reflect.Uint64:
-		var err error
-		if *dest.(*uint64), err = strconv.ParseUint(value.(string), 10, 64); err != nil {
-			return fmt.Errorf("strconv.ParseUint(%v): %w", value, err)
+// variantInt converts an integer property value of any width to int64, returning an error if it is
+// not an integer, or falls outside [min, max].
+func variantInt(value interface{}, min, max int64) (int64, error) {
+	var n int64
+	switch v := value.(type) {
+	case int8:
+		n = int64(v)
+	case int16:
+		n = int64(v)
+	case int32:
+		n = int64(v)
+	case int64:
+		n = v
+	case uint8:
+		n = int64(v)
+	case uint16:
+		n = int64(v)
+	case uint32:
+		n = int64(v)
+	case uint64:
+		if v > math.MaxInt64 {
+			return 0, fmt.Errorf("property value %d out of range [%d, %d]", v, min, max)
 		}
+		n = int64(v)
 	default:
-		return fmt.Errorf("unknown type for %v: %v", value, dKind)
+		return 0, fmt.Errorf("ignoring property value %v due to type mismatch (got: %T, want: integer)", value, value)
 	}
-	return nil
+	if n < min || n > max {
+		return 0, fmt.Errorf("property value %d out of range [%d, %d]", n, min, max)
+	}
+	return n, nil
 }
 
 // GetDisks queries for local disks.
@@ -471,20 +1262,20 @@ func assignVariant(value interface{}, dest interface{}) error {
 // To get specific disks, provide a valid WMI query filter string, for example:
 //		svc.GetDisks("WHERE Number=1")
 //		svc.GetDisks("WHERE IsSystem=True")
-func (svc Service) GetDisks(filter string) (DiskSet, error) {
+func (svc *Service) GetDisks(filter string) (DiskSet, error) {
+	defer svc.lock()()
 	dset := DiskSet{}
 	query := "SELECT * FROM MSFT_DISK"
 	if filter != "" {
 		query = fmt.Sprintf("%s %s", query, filter)
 	}
-	raw, err := oleutil.CallMethod(svc.wmiSvc, "ExecQuery", query)
+	result, err := svc.execQuery(query)
 	if err != nil {
-		return dset, fmt.Errorf("ExecQuery(%s): %w", query, err)
+		return dset, err
 	}
-	result := raw.ToIDispatch()
 	defer result.Release()
 
-	countVar, err := oleutil.GetProperty(result, "Count")
+	countVar, err := svc.wmi().GetProperty(result, "Count")
 	if err != nil {
 		return dset, fmt.Errorf("oleutil.GetProperty(Count): %w", err)
 	}
@@ -492,11 +1283,12 @@ func (svc Service) GetDisks(filter string) (DiskSet, error) {
 
 	for i := 0; i < count; i++ {
 		d := Disk{}
-		itemRaw, err := oleutil.CallMethod(result, "ItemIndex", i)
+		item, err := svc.itemIndex(result, i, query)
 		if err != nil {
-			return dset, fmt.Errorf("oleutil.CallMethod(ItemIndex, %d): %w", i, err)
+			return dset, err
 		}
-		d.handle = itemRaw.ToIDispatch()
+		d.handle = item
+		d.svc = svc
 		if err := d.Query(); err != nil {
 			return dset, err
 		}
@@ -505,3 +1297,18 @@ func (svc Service) GetDisks(filter string) (DiskSet, error) {
 
 	return dset, nil
 }
+
+// GetDisksBySize queries for local disks with a Size between min and max bytes, inclusive.
+// A max of zero leaves the range unbounded above.
+//
+// Close() must be called on the resulting DiskSet to ensure all disks are released.
+//
+// Example: get all disks between 100GiB and 1TiB
+//		svc.GetDisksBySize(100<<30, 1<<40)
+func (svc *Service) GetDisksBySize(min, max uint64) (DiskSet, error) {
+	filter, err := sizeFilter(min, max)
+	if err != nil {
+		return DiskSet{}, err
+	}
+	return svc.GetDisks(filter)
+}
@@ -0,0 +1,287 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"reflect"
+	"sync/atomic"
+	"testing"
+
+	"github.com/go-ole/go-ole"
+)
+
+func TestDefaultMSRSize(t *testing.T) {
+	tests := []struct {
+		in   uint64
+		want uint64
+	}{
+		{8 << 30, 32 << 20},
+		{16<<30 - 1, 32 << 20},
+		{16 << 30, 128 << 20},
+		{2 << 40, 128 << 20},
+	}
+	for _, tt := range tests {
+		if got := DefaultMSRSize(tt.in); got != tt.want {
+			t.Errorf("DefaultMSRSize(%d) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestFreeExtents(t *testing.T) {
+	const mib = 1 << 20
+	tests := []struct {
+		desc  string
+		size  uint64
+		style PartitionStyle
+		used  []Extent
+		want  []Extent
+	}{
+		{
+			desc:  "empty gpt",
+			size:  100 * mib,
+			style: GptStyle,
+			want:  []Extent{{Offset: mib, Size: 98 * mib}},
+		},
+		{
+			desc:  "empty mbr",
+			size:  100 * mib,
+			style: MbrStyle,
+			want:  []Extent{{Offset: mib, Size: 99 * mib}},
+		},
+		{
+			desc:  "gap between partitions",
+			size:  100 * mib,
+			style: GptStyle,
+			used: []Extent{
+				{Offset: 50 * mib, Size: 10 * mib},
+				{Offset: mib, Size: 16 * mib},
+			},
+			want: []Extent{
+				{Offset: 17 * mib, Size: 33 * mib},
+				{Offset: 60 * mib, Size: 39 * mib},
+			},
+		},
+		{
+			desc:  "full",
+			size:  100 * mib,
+			style: MbrStyle,
+			used:  []Extent{{Offset: mib, Size: 99 * mib}},
+		},
+		{
+			desc:  "unaligned gap",
+			size:  100 * mib,
+			style: MbrStyle,
+			used: []Extent{
+				{Offset: mib, Size: 10*mib + 512},
+				{Offset: 12 * mib, Size: 88 * mib},
+			},
+		},
+	}
+	for _, tt := range tests {
+		got := freeExtents(tt.size, 512, tt.style, tt.used)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: freeExtents() = %v, want %v", tt.desc, got, tt.want)
+		}
+	}
+}
+
+func TestParseGptType(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    GptType
+		wantErr bool
+	}{
+		{in: "EFI", want: GptTypes.SystemPartition},
+		{in: "msr", want: GptTypes.MicrosoftReserved},
+		{in: "BasicData", want: GptTypes.BasicData},
+		{in: "WindowsRE", want: GptTypes.MicrosoftRecovery},
+		{in: "{ebd0a0a2-b9e5-4433-87c0-68b6b72699c7}", want: GptTypes.BasicData},
+		{in: "EBD0A0A2-B9E5-4433-87C0-68B6B72699C7", want: GptTypes.BasicData},
+		{in: "{ebd0a0a2-b9e5-4433-87c0-68b6b72699c7", wantErr: true},
+		{in: "ebd0a0a2-b9e5-4433-87c0", wantErr: true},
+		{in: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseGptType(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseGptType(%q) returned error %v, want error: %t", tt.in, err, tt.wantErr)
+		}
+		if got != tt.want {
+			t.Errorf("ParseGptType(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestHealthActions(t *testing.T) {
+	tests := []struct {
+		health   int32
+		opStatus []int32
+		want     []string
+	}{
+		{0, []int32{2}, []string{HealthActionNone}},
+		{1, []int32{2}, []string{HealthActionRetire}},
+		{2, nil, []string{HealthActionReplace}},
+		{1, []int32{5, 2}, []string{HealthActionRetire}},
+		{2, []int32{7, 0xD014}, []string{HealthActionReplace}},
+		{0, []int32{13, 0xD013}, []string{HealthActionReconnect, HealthActionOnline}},
+	}
+	for _, tt := range tests {
+		got := healthActions(tt.health, tt.opStatus)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("healthActions(%d, %v) = %v, want %v", tt.health, tt.opStatus, got, tt.want)
+		}
+	}
+}
+
+func TestPartitionIsLDM(t *testing.T) {
+	tests := []struct {
+		part Partition
+		want bool
+	}{
+		{Partition{MbrType: int32(MbrTypes.IFS)}, false},
+		{Partition{MbrType: int32(MbrTypes.LDM)}, true},
+		{Partition{GptType: string(GptTypes.BasicData)}, false},
+		{Partition{GptType: string(GptTypes.LDMMetadata)}, true},
+		{Partition{GptType: "{AF9B60A0-1431-4F62-BC68-3311714A69AD}"}, true},
+	}
+	for _, tt := range tests {
+		if got := tt.part.isLDM(); got != tt.want {
+			t.Errorf("isLDM(%d, %q) = %t, want %t", tt.part.MbrType, tt.part.GptType, got, tt.want)
+		}
+	}
+}
+
+func TestPartitionType(t *testing.T) {
+	tests := []struct {
+		part Partition
+		want string
+	}{
+		{Partition{GptType: string(GptTypes.SystemPartition)}, "System"},
+		{Partition{GptType: "{EBD0A0A2-B9E5-4433-87C0-68B6B72699C7}"}, "Basic"},
+		{Partition{GptType: "{00000000-0000-0000-0000-000000000000}"}, "Unknown({00000000-0000-0000-0000-000000000000})"},
+		{Partition{MbrType: int32(MbrTypes.IFS)}, "IFS"},
+		{Partition{MbrType: 0x83}, "Unknown(131)"},
+	}
+	for _, tt := range tests {
+		if got := tt.part.Type(); got != tt.want {
+			t.Errorf("Type(%d, %q) = %q, want %q", tt.part.MbrType, tt.part.GptType, got, tt.want)
+		}
+	}
+}
+
+func TestAssignVariant(t *testing.T) {
+	var u64 uint64
+	var i32 int32
+	var u8 uint8
+	var b bool
+	tests := []struct {
+		in      interface{}
+		dest    interface{}
+		want    interface{}
+		wantErr bool
+	}{
+		// Sizes above 4 GiB overflow int32, and arrive as strings.
+		{"5000000000", &u64, uint64(5000000000), false},
+		{"18446744073709551615", &u64, uint64(18446744073709551615), false},
+		{"18446744073709551616", &u64, uint64(0), true},
+		{int32(4096), &u64, uint64(4096), false},
+		{int64(8 << 40), &u64, uint64(8 << 40), false},
+		{uint64(1 << 63), &u64, uint64(1 << 63), false},
+		{int32(-1), &u64, uint64(0), true},
+		{"not a number", &u64, uint64(0), true},
+		{int32(7), &i32, int32(7), false},
+		{uint16(11), &i32, int32(11), false},
+		{uint32(1 << 31), &i32, int32(0), true},
+		{"7", &i32, int32(0), true},
+		{uint8(42), &u8, uint8(42), false},
+		{int32(300), &u8, uint8(0), true},
+		{true, &b, true, false},
+		{int32(1), &b, false, true},
+	}
+	for _, tt := range tests {
+		u64, i32, u8, b = 0, 0, 0, false
+		err := assignVariant(tt.in, tt.dest)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("assignVariant(%v (%T)) returned error %v, want error: %t", tt.in, tt.in, err, tt.wantErr)
+		}
+		if got := reflect.ValueOf(tt.dest).Elem().Interface(); got != tt.want {
+			t.Errorf("assignVariant(%v (%T)) = %v, want %v", tt.in, tt.in, got, tt.want)
+		}
+	}
+
+	// A nil property leaves the destination untouched.
+	u64 = 1
+	if err := assignVariant(nil, &u64); err != nil || u64 != 1 {
+		t.Errorf("assignVariant(nil) = %d, %v, want 1, nil", u64, err)
+	}
+}
+
+func TestFirstFixed(t *testing.T) {
+	tests := []struct {
+		disks   []Disk
+		want    int32
+		wantErr bool
+	}{
+		{[]Disk{{Number: 0, BusType: int32(BusTypeUSB)}, {Number: 1, BusType: int32(BusTypeNVMe)}}, 1, false},
+		{[]Disk{{Number: 2, BusType: int32(BusTypeSATA)}, {Number: 1, BusType: int32(BusTypeNVMe)}}, 1, false},
+		{[]Disk{{Number: 0, BusType: int32(BusTypeSD)}, {Number: 3, BusType: int32(BusTypeFileBackedVirtual)}}, 0, true},
+		{nil, 0, true},
+	}
+	for _, tt := range tests {
+		s := DiskSet{Disks: tt.disks}
+		got, err := s.FirstFixed()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("FirstFixed() returned error %v, want error: %t", err, tt.wantErr)
+		}
+		if err == nil && got.Number != tt.want {
+			t.Errorf("FirstFixed() = disk %d, want disk %d", got.Number, tt.want)
+		}
+	}
+}
+
+func TestSetDiskIdentity(t *testing.T) {
+	svc := &Service{protectSystemDisk: &atomic.Value{}}
+	svc.ProtectSystemDisk(true)
+	gpt := Disk{Number: 1, PartitionStyle: int32(GptStyle), svc: svc}
+	if _, err := gpt.SetGUID("not-a-guid"); !errors.Is(err, ErrInvalidParameter) {
+		t.Errorf("SetGUID(not-a-guid) returned %v, want %v", err, ErrInvalidParameter)
+	}
+
+	gpt.IsBoot = true
+	if _, err := gpt.SetGUID("6F2E6C1B-4C5D-4A3B-9C8E-1A2B3C4D5E6F"); !errors.Is(err, ErrSystemDisk) {
+		t.Errorf("SetGUID() on the boot disk returned %v, want %v", err, ErrSystemDisk)
+	}
+	var sent interface{}
+	svc.caller = &fakeWMI{methods: map[string]func(params ...interface{}) (*ole.VARIANT, error){
+		"SetAttributes": func(params ...interface{}) (*ole.VARIANT, error) {
+			sent = params[2]
+			return intVariant(0), nil
+		},
+	}}
+	gpt.AllowSystemDisk = true
+	want := "{6f2e6c1b-4c5d-4a3b-9c8e-1a2b3c4d5e6f}"
+	if _, err := gpt.SetGUID("6F2E6C1B-4C5D-4A3B-9C8E-1A2B3C4D5E6F"); err != nil {
+		t.Errorf("SetGUID() returned %v", err)
+	}
+	if sent != want || gpt.GUID != want {
+		t.Errorf("SetGUID() sent %v and set GUID %q, want %q", sent, gpt.GUID, want)
+	}
+
+	mbr := Disk{Number: 0, PartitionStyle: int32(MbrStyle), IsSystem: true, svc: svc}
+	if _, err := mbr.SetSignature(0x1234abcd); !errors.Is(err, ErrSystemDisk) {
+		t.Errorf("SetSignature() on the system disk returned %v, want %v", err, ErrSystemDisk)
+	}
+}
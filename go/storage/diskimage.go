@@ -0,0 +1,231 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"fmt"
+
+	"github.com/google/logger"
+	"github.com/go-ole/go-ole"
+)
+
+// Disk image storage types, as reported in DiskImage.StorageType.
+const (
+	DiskImageISO  int32 = 1
+	DiskImageVHD  int32 = 2
+	DiskImageVHDX int32 = 3
+	DiskImageVHDS int32 = 4
+)
+
+// Access modes accepted by MSFT_DiskImage.Mount.
+const (
+	diskImageReadWrite int32 = 2
+	diskImageReadOnly  int32 = 3
+)
+
+// DiskImage represents a MSFT_DiskImage object: a virtual hard disk (VHD or VHDX) or ISO file,
+// which can be attached as a disk.
+//
+// Ref: https://docs.microsoft.com/en-us/previous-versions/windows/desktop/stormgmt/msft-diskimage
+type DiskImage struct {
+	ImagePath   string
+	DevicePath  string
+	Attached    bool
+	Number      int32
+	FileSize    uint64
+	Size        uint64
+	StorageType int32
+
+	handle *ole.IDispatch
+	svc    *Service
+}
+
+// Close releases the handle to the disk image.
+func (i *DiskImage) Close() {
+	if i.handle != nil {
+		i.handle.Release()
+	}
+}
+
+// Mount attaches the disk image as a disk, read-only if readOnly is set, and refreshes the image
+// state, so that Number holds the number of the attached disk.
+//
+// Example:
+//		img.Mount(true)
+//		d, err := img.GetDisk()
+//
+// Ref: https://docs.microsoft.com/en-us/previous-versions/windows/desktop/stormgmt/mount-msft-diskimage
+func (i *DiskImage) Mount(readOnly bool) (ExtendedStatus, error) {
+	access := diskImageReadWrite
+	if readOnly {
+		access = diskImageReadOnly
+	}
+	return i.call("Mount", "mount", map[string]interface{}{"Access": access})
+}
+
+// Dismount detaches the disk image.
+//
+// Ref: https://docs.microsoft.com/en-us/previous-versions/windows/desktop/stormgmt/dismount-msft-diskimage
+func (i *DiskImage) Dismount() (ExtendedStatus, error) {
+	return i.call("Dismount", "dismount", nil)
+}
+
+// call runs the disk image method with named parameters, and refreshes the image state on success.
+func (i *DiskImage) call(method, op string, params map[string]interface{}) (ExtendedStatus, error) {
+	stat := ExtendedStatus{}
+	out, err := i.svc.execMethod(i.handle, method, params)
+	if err != nil {
+		return stat, fmt.Errorf("%s(%s): %w", method, i.ImagePath, err)
+	}
+	defer out.Release()
+	if es, err := i.svc.getProperty(out, "ExtendedStatus"); err == nil {
+		stat = i.svc.newExtendedStatus(es)
+	}
+	ret, err := i.svc.getProperty(out, "ReturnValue")
+	if err != nil {
+		return stat, fmt.Errorf("%s(%s): %w", method, i.ImagePath, err)
+	} else if val, ok := ret.Value().(int32); val != 0 || !ok {
+		return stat, &StorageError{op, val}
+	}
+	if err := i.svc.refreshObject(i.handle); err != nil {
+		return stat, fmt.Errorf("%s(%s): %w", method, i.ImagePath, err)
+	}
+	return stat, i.Query()
+}
+
+// GetDisk returns the disk the image is attached as.
+//
+// Close() must be called on the resulting Disk.
+func (i *DiskImage) GetDisk() (Disk, error) {
+	if !i.Attached {
+		return Disk{}, fmt.Errorf("GetDisk(%s): image is not attached", i.ImagePath)
+	}
+	dset, err := i.svc.GetDisks(fmt.Sprintf("WHERE Number=%d", i.Number))
+	if err != nil {
+		return Disk{}, fmt.Errorf("GetDisk(%s): %w", i.ImagePath, err)
+	}
+	if len(dset.Disks) != 1 {
+		dset.Close()
+		return Disk{}, fmt.Errorf("GetDisk(%s): disk %d: %w", i.ImagePath, i.Number, ErrNotFound)
+	}
+	return dset.Disks[0], nil
+}
+
+// Query reads and populates the disk image state.
+func (i *DiskImage) Query() error {
+	if i.handle == nil {
+		return fmt.Errorf("invalid handle")
+	}
+
+	for _, p := range [][]interface{}{
+		[]interface{}{"ImagePath", &i.ImagePath},
+		[]interface{}{"DevicePath", &i.DevicePath},
+		[]interface{}{"Attached", &i.Attached},
+		[]interface{}{"Number", &i.Number},
+		[]interface{}{"FileSize", &i.FileSize},
+		[]interface{}{"Size", &i.Size},
+		[]interface{}{"StorageType", &i.StorageType},
+	} {
+		prop, err := i.svc.getProperty(i.handle, p[0].(string))
+		if err != nil {
+			return err
+		}
+		if err := assignVariant(prop.Value(), p[1]); err != nil {
+			logger.Warningf("assignVariant(%s): %v", p[0].(string), err)
+		}
+	}
+	// Number is null while the image is not attached.
+	if !i.Attached {
+		i.Number = -1
+	}
+	return nil
+}
+
+// GetDiskImage returns the disk image for the VHD, VHDX or ISO file at path, which must be an
+// absolute path.
+//
+// Close() must be called on the resulting DiskImage.
+//
+// Example: attach a golden image read-only, and inspect its volumes
+//		img, err := svc.GetDiskImage(`D:\images\golden.vhdx`)
+//		if err != nil {
+//			return err
+//		}
+//		defer img.Close()
+//		if _, err := img.Mount(true); err != nil {
+//			return err
+//		}
+//		defer img.Dismount()
+func (svc *Service) GetDiskImage(path string) (DiskImage, error) {
+	img := DiskImage{svc: svc}
+	filter, err := NewFilter().Equals("ImagePath", path).Build()
+	if err != nil {
+		return img, fmt.Errorf("GetDiskImage(%s): %w", path, err)
+	}
+	// MSFT_DiskImage cannot be enumerated, only queried by ImagePath.
+	query := "SELECT * FROM MSFT_DiskImage " + filter
+
+	defer svc.lock()()
+	result, err := svc.execQuery(query)
+	if err != nil {
+		return img, fmt.Errorf("GetDiskImage(%s): %w", path, err)
+	}
+	defer result.Release()
+	countVar, err := svc.wmi().GetProperty(result, "Count")
+	if err != nil {
+		return img, fmt.Errorf("oleutil.GetProperty(Count): %w", err)
+	}
+	if countVar.Val == 0 {
+		return img, fmt.Errorf("GetDiskImage(%s): %w", path, ErrNotFound)
+	}
+	item, err := svc.itemIndex(result, 0, query)
+	if err != nil {
+		return img, err
+	}
+	img.handle = item
+	return img, img.Query()
+}
+
+// MountDiskImage attaches the VHD, VHDX or ISO file at path, read-only if readOnly is set, and
+// returns the disk it is attached as.
+//
+// Close() must be called on the resulting Disk. The image stays attached until DismountDiskImage is called.
+//
+// Example:
+//		d, err := svc.MountDiskImage(`D:\images\build.vhdx`, false)
+func (svc *Service) MountDiskImage(path string, readOnly bool) (Disk, error) {
+	img, err := svc.GetDiskImage(path)
+	if err != nil {
+		return Disk{}, fmt.Errorf("MountDiskImage: %w", err)
+	}
+	defer img.Close()
+	if _, err := img.Mount(readOnly); err != nil {
+		return Disk{}, fmt.Errorf("MountDiskImage: %w", err)
+	}
+	return img.GetDisk()
+}
+
+// DismountDiskImage detaches the VHD, VHDX or ISO file at path.
+func (svc *Service) DismountDiskImage(path string) error {
+	img, err := svc.GetDiskImage(path)
+	if err != nil {
+		return fmt.Errorf("DismountDiskImage: %w", err)
+	}
+	defer img.Close()
+	if _, err := img.Dismount(); err != nil {
+		return fmt.Errorf("DismountDiskImage: %w", err)
+	}
+	return nil
+}
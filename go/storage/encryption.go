@@ -0,0 +1,260 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"fmt"
+
+	"github.com/google/logger"
+	"github.com/go-ole/go-ole"
+)
+
+// encryptionNamespace is the WMI namespace hosting the BitLocker Drive Encryption provider.
+const encryptionNamespace = `ROOT\CIMV2\Security\MicrosoftVolumeEncryption`
+
+// ConnectVolumeEncryption connects to the WMI provider for BitLocker Drive Encryption. The resulting
+// Service only supports GetEncryptableVolumes. You must call Close() to release the provider when finished.
+//
+// Example: storage.ConnectVolumeEncryption()
+func ConnectVolumeEncryption() (Service, error) {
+	return NewService(NamespaceVolumeEncryption)
+}
+
+// Encryption methods accepted by EncryptableVolume.Encrypt.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/secprov/encrypt-win32-encryptablevolume
+const (
+	EncryptionDefault   int32 = 0
+	EncryptionAES128    int32 = 3
+	EncryptionAES256    int32 = 4
+	EncryptionXTSAES128 int32 = 6
+	EncryptionXTSAES256 int32 = 7
+)
+
+// EncryptDataOnly may be passed as an Encrypt flag to encrypt only the used space of the volume.
+const EncryptDataOnly int32 = 0x00000001
+
+// Protection states reported by EncryptableVolume.GetProtectionStatus.
+const (
+	ProtectionOff     int32 = 0
+	ProtectionOn      int32 = 1
+	ProtectionUnknown int32 = 2
+)
+
+// Conversion states reported in ConversionStatus.Status.
+const (
+	ConversionFullyDecrypted   int32 = 0
+	ConversionFullyEncrypted   int32 = 1
+	ConversionEncrypting       int32 = 2
+	ConversionDecrypting       int32 = 3
+	ConversionEncryptionPaused int32 = 4
+	ConversionDecryptionPaused int32 = 5
+)
+
+// EncryptableVolume represents a Win32_EncryptableVolume object, a volume which can be protected
+// with BitLocker Drive Encryption.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/secprov/win32-encryptablevolume
+type EncryptableVolume struct {
+	DeviceID           string
+	PersistentVolumeID string
+	DriveLetter        string
+	ProtectionStatus   int32
+	ConversionStatus   int32
+	EncryptionMethod   int32
+	VolumeType         int32
+
+	handle *ole.IDispatch
+	svc    *Service
+}
+
+// ConversionStatus reports the progress of encrypting or decrypting a volume.
+type ConversionStatus struct {
+	Status               int32
+	EncryptionPercentage int32
+	EncryptionFlags      int32
+	WipingStatus         int32
+	WipingPercentage     int32
+}
+
+// Close releases the handle to the encryptable volume.
+func (e *EncryptableVolume) Close() {
+	if e.handle != nil {
+		e.handle.Release()
+	}
+}
+
+// Encrypt begins encrypting the volume with the given encryption method and flags, and returns
+// without waiting for encryption to complete. A key protector must have been added to the volume
+// first. Use GetConversionStatus to follow progress.
+//
+// Example:
+//		e.Encrypt(storage.EncryptionXTSAES256, storage.EncryptDataOnly)
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/secprov/encrypt-win32-encryptablevolume
+func (e *EncryptableVolume) Encrypt(encryptionMethod int32, flags int32) error {
+	res, err := e.svc.wmi().CallMethod(e.handle, "Encrypt", encryptionMethod, flags)
+	if err != nil {
+		return fmt.Errorf("Encrypt: %w", err)
+	} else if val, ok := res.Value().(int32); val != 0 || !ok {
+		return &StorageError{"encryption", val}
+	}
+	return nil
+}
+
+// GetConversionStatus returns the encryption state of the volume, and the percentage encrypted.
+//
+// Example: wait for encryption to complete
+//		for {
+//			cs, err := e.GetConversionStatus()
+//			if err != nil || cs.Status == storage.ConversionFullyEncrypted {
+//				break
+//			}
+//			time.Sleep(time.Minute)
+//		}
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/secprov/getconversionstatus-win32-encryptablevolume
+func (e *EncryptableVolume) GetConversionStatus() (ConversionStatus, error) {
+	cs := ConversionStatus{}
+	var status, pct, flags, wiping, wipingPct ole.VARIANT
+	for _, v := range []*ole.VARIANT{&status, &pct, &flags, &wiping, &wipingPct} {
+		ole.VariantInit(v)
+	}
+	res, err := e.svc.wmi().CallMethod(e.handle, "GetConversionStatus", &status, &pct, &flags, &wiping, &wipingPct)
+	if err != nil {
+		return cs, fmt.Errorf("GetConversionStatus: %w", err)
+	} else if val, ok := res.Value().(int32); val != 0 || !ok {
+		return cs, &StorageError{"conversion status query", val}
+	}
+	for _, p := range []struct {
+		v    *ole.VARIANT
+		dest *int32
+	}{
+		{&status, &cs.Status},
+		{&pct, &cs.EncryptionPercentage},
+		{&flags, &cs.EncryptionFlags},
+		{&wiping, &cs.WipingStatus},
+		{&wipingPct, &cs.WipingPercentage},
+	} {
+		if err := assignVariant(p.v.Value(), p.dest); err != nil {
+			logger.Warningf("GetConversionStatus: %v", err)
+		}
+	}
+	e.ConversionStatus = cs.Status
+	return cs, nil
+}
+
+// GetProtectionStatus returns whether BitLocker protection is on for the volume, as one of
+// ProtectionOff, ProtectionOn or ProtectionUnknown.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/secprov/getprotectionstatus-win32-encryptablevolume
+func (e *EncryptableVolume) GetProtectionStatus() (int32, error) {
+	var status ole.VARIANT
+	ole.VariantInit(&status)
+	res, err := e.svc.wmi().CallMethod(e.handle, "GetProtectionStatus", &status)
+	if err != nil {
+		return ProtectionUnknown, fmt.Errorf("GetProtectionStatus: %w", err)
+	} else if val, ok := res.Value().(int32); val != 0 || !ok {
+		return ProtectionUnknown, &StorageError{"protection status query", val}
+	}
+	if err := assignVariant(status.Value(), &e.ProtectionStatus); err != nil {
+		return ProtectionUnknown, fmt.Errorf("GetProtectionStatus: %w", err)
+	}
+	return e.ProtectionStatus, nil
+}
+
+// Query reads and populates the encryptable volume state.
+func (e *EncryptableVolume) Query() error {
+	if e.handle == nil {
+		return fmt.Errorf("invalid handle")
+	}
+
+	for _, p := range [][]interface{}{
+		[]interface{}{"DeviceID", &e.DeviceID},
+		[]interface{}{"PersistentVolumeID", &e.PersistentVolumeID},
+		[]interface{}{"DriveLetter", &e.DriveLetter},
+		[]interface{}{"ProtectionStatus", &e.ProtectionStatus},
+		[]interface{}{"ConversionStatus", &e.ConversionStatus},
+		[]interface{}{"EncryptionMethod", &e.EncryptionMethod},
+		[]interface{}{"VolumeType", &e.VolumeType},
+	} {
+		prop, err := e.svc.getProperty(e.handle, p[0].(string))
+		if err != nil {
+			return err
+		}
+		if err := assignVariant(prop.Value(), p[1]); err != nil {
+			logger.Warningf("assignVariant(%s): %v", p[0].(string), err)
+		}
+	}
+	return nil
+}
+
+// An EncryptableVolumeSet contains one or more EncryptableVolumes.
+type EncryptableVolumeSet struct {
+	Volumes []EncryptableVolume
+}
+
+// Close releases all EncryptableVolume handles inside an EncryptableVolumeSet.
+func (s *EncryptableVolumeSet) Close() {
+	for _, e := range s.Volumes {
+		e.Close()
+	}
+}
+
+// GetEncryptableVolumes queries for volumes which can be protected with BitLocker. The Service must
+// have been created with ConnectVolumeEncryption.
+//
+// Close() must be called on the resulting EncryptableVolumeSet to ensure all volumes are released.
+//
+// Get all encryptable volumes:
+//		svc.GetEncryptableVolumes("")
+//
+// To get specific volumes, provide a valid WMI query filter string, for example:
+//		svc.GetEncryptableVolumes("WHERE DriveLetter='C:'")
+func (svc *Service) GetEncryptableVolumes(filter string) (EncryptableVolumeSet, error) {
+	defer svc.lock()()
+	eset := EncryptableVolumeSet{}
+	query := "SELECT * FROM Win32_EncryptableVolume"
+	if filter != "" {
+		query = fmt.Sprintf("%s %s", query, filter)
+	}
+	result, err := svc.execQuery(query)
+	if err != nil {
+		return eset, err
+	}
+	defer result.Release()
+
+	countVar, err := svc.wmi().GetProperty(result, "Count")
+	if err != nil {
+		return eset, fmt.Errorf("oleutil.GetProperty(Count): %w", err)
+	}
+	count := int(countVar.Val)
+
+	for i := 0; i < count; i++ {
+		e := EncryptableVolume{}
+		item, err := svc.itemIndex(result, i, query)
+		if err != nil {
+			return eset, err
+		}
+		e.handle = item
+		e.svc = svc
+		if err := e.Query(); err != nil {
+			return eset, err
+		}
+		eset.Volumes = append(eset.Volumes, e)
+	}
+
+	return eset, nil
+}
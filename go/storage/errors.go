@@ -0,0 +1,137 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrAccessDenied indicates a storage method failed because access was denied.
+	ErrAccessDenied = errors.New("access denied")
+	// ErrInUse indicates a storage method failed because the object is in use, such as a volume which
+	// could not be locked or dismounted because it has open handles.
+	ErrInUse = errors.New("object is in use")
+	// ErrNotFound indicates a storage method failed because an object it refers to does not exist, or
+	// that no object matched the requested identifier.
+	ErrNotFound = errors.New("object not found")
+	// ErrInvalidParameter indicates a storage method was called with an invalid parameter.
+	ErrInvalidParameter = errors.New("invalid parameter")
+
+	// ErrVolumeInUse is ErrInUse, returned when a volume could not be locked or dismounted because it
+	// has open handles.
+	ErrVolumeInUse = ErrInUse
+	// ErrVolumeNotFound is ErrNotFound, returned when no volume matched the requested identifier.
+	ErrVolumeNotFound = ErrNotFound
+	// ErrPartitionNotFound is ErrNotFound, returned when no partition matched the requested identifier.
+	ErrPartitionNotFound = ErrNotFound
+)
+
+// codeErrors maps the return codes of storage methods to the sentinel errors they match with errors.Is.
+//
+// The low values are the codes common to all Storage Management API methods, including 6 (In Use),
+// which the MSFT classes inherit from CIM. 40001 is the common access denied code. The API has no
+// common not found code, so ErrNotFound is matched from the HRESULTs returned by providers which
+// report Win32 and WMI errors directly, such as Win32_EncryptableVolume, and is also returned by the
+// lookups of this package when no object matches.
+var codeErrors = map[uint32]error{
+	1:          ErrNotSupported,
+	5:          ErrInvalidParameter,
+	6:          ErrInUse,
+	40001:      ErrAccessDenied,
+	0x80070005: ErrAccessDenied,     // E_ACCESSDENIED
+	0x80070020: ErrInUse,            // ERROR_SHARING_VIOLATION
+	0x800700AA: ErrInUse,            // ERROR_BUSY
+	0x80070057: ErrInvalidParameter, // E_INVALIDARG
+	0x80070002: ErrNotFound,         // ERROR_FILE_NOT_FOUND
+	0x80070490: ErrNotFound,         // ERROR_NOT_FOUND
+	0x80041002: ErrNotFound,         // WBEM_E_NOT_FOUND
+	0x80041008: ErrInvalidParameter, // WBEM_E_INVALID_PARAMETER
+}
+
+// StorageError is returned when a storage method completes with a non-zero return code.
+//
+// Callers can inspect the code with errors.As, or test for the common failures with errors.Is:
+//		var se *storage.StorageError
+//		if errors.As(err, &se) {
+//			logger.Errorf("%s failed with %d", se.Operation, se.Code)
+//		}
+//		if errors.Is(err, storage.ErrAccessDenied) {
+//			// re-run elevated
+//		}
+type StorageError struct {
+	Operation string
+	Code      int32
+}
+
+func (e *StorageError) Error() string {
+	// HRESULTs read better in hex.
+	if e.Code < 0 {
+		return fmt.Sprintf("error code returned during %s: %#x", e.Operation, uint32(e.Code))
+	}
+	return fmt.Sprintf("error code returned during %s: %d", e.Operation, e.Code)
+}
+
+// Is reports whether target is the sentinel error for the return code.
+func (e *StorageError) Is(target error) bool {
+	sentinel, ok := codeErrors[uint32(e.Code)]
+	return ok && sentinel == target
+}
+
+// joinedError holds several errors, like errors.Join, which is not available to this module. It
+// matches errors.Is and errors.As if any of its errors does.
+type joinedError []error
+
+// joinErrors returns nil if errs is empty, the error itself if errs holds a single error, and
+// otherwise an error holding all of errs.
+func joinErrors(errs []error) error {
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	}
+	return joinedError(errs)
+}
+
+func (e joinedError) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Is reports whether any of the errors matches target.
+func (e joinedError) Is(target error) bool {
+	for _, err := range e {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first of the errors matching target, and if one does, sets target to that error.
+func (e joinedError) As(target interface{}) bool {
+	for _, err := range e {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,79 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestStorageError(t *testing.T) {
+	tests := []struct {
+		err     error
+		target  error
+		want    bool
+		wantMsg string
+	}{
+		{&StorageError{"disk wipe", 40001}, ErrAccessDenied, true, "error code returned during disk wipe: 40001"},
+		{&StorageError{"resize", 5}, ErrInvalidParameter, true, "error code returned during resize: 5"},
+		{&StorageError{"resize", 5}, ErrAccessDenied, false, "error code returned during resize: 5"},
+		{&StorageError{"encryption", -2147024864}, ErrInUse, true, "error code returned during encryption: 0x80070020"},
+		{&StorageError{"partition deletion", 6}, ErrInUse, true, "error code returned during partition deletion: 6"},
+		{&StorageError{"partition deletion", 6}, ErrVolumeInUse, true, "error code returned during partition deletion: 6"},
+		{&StorageError{"encryption", -2147023728}, ErrNotFound, true, "error code returned during encryption: 0x80070490"},
+		{fmt.Errorf("Format: %w", &StorageError{"formatting", 2}), ErrNotFound, false, "Format: error code returned during formatting: 2"},
+	}
+	for _, tt := range tests {
+		if got := errors.Is(tt.err, tt.target); got != tt.want {
+			t.Errorf("errors.Is(%v, %v) = %t, want %t", tt.err, tt.target, got, tt.want)
+		}
+		if got := tt.err.Error(); got != tt.wantMsg {
+			t.Errorf("Error() = %q, want %q", got, tt.wantMsg)
+		}
+	}
+
+	var se *StorageError
+	if !errors.As(fmt.Errorf("Delete: %w", &StorageError{"deletion", 4}), &se) || se.Code != 4 {
+		t.Errorf("errors.As() did not return the StorageError")
+	}
+}
+
+func TestJoinErrors(t *testing.T) {
+	if err := joinErrors(nil); err != nil {
+		t.Errorf("joinErrors(nil) = %v, want nil", err)
+	}
+	if err := joinErrors([]error{ErrNotFound}); err != ErrNotFound {
+		t.Errorf("joinErrors(%v) = %v, want the error itself", ErrNotFound, err)
+	}
+
+	err := joinErrors([]error{
+		fmt.Errorf("Refresh(C): %w", ErrQueryTimeout),
+		fmt.Errorf("Refresh(D): %w", &StorageError{"flush", 40001}),
+	})
+	if got, want := err.Error(), "Refresh(C): WMI query timed out\nRefresh(D): error code returned during flush: 40001"; got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+	if !errors.Is(err, ErrQueryTimeout) || !errors.Is(err, ErrAccessDenied) {
+		t.Errorf("errors.Is() did not match the joined errors")
+	}
+	if errors.Is(err, ErrNotFound) {
+		t.Errorf("errors.Is(%v, ErrNotFound) = true, want false", err)
+	}
+	var se *StorageError
+	if !errors.As(err, &se) || se.Code != 40001 {
+		t.Errorf("errors.As() did not return the StorageError")
+	}
+}
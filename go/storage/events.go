@@ -0,0 +1,180 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/logger"
+	"github.com/go-ole/go-ole"
+)
+
+// VolumeEventType describes what happened to the volume in a VolumeEvent.
+type VolumeEventType int
+
+const (
+	// VolumeAdded indicates a volume appeared, such as when media is inserted or a partition is created.
+	VolumeAdded VolumeEventType = iota + 1
+	// VolumeRemoved indicates a volume disappeared.
+	VolumeRemoved
+)
+
+// String returns the name of the event type.
+func (t VolumeEventType) String() string {
+	switch t {
+	case VolumeAdded:
+		return "Added"
+	case VolumeRemoved:
+		return "Removed"
+	}
+	return fmt.Sprintf("Unknown(%d)", int(t))
+}
+
+// A VolumeEvent reports a volume which was added or removed.
+//
+// Volume holds the state of the volume at the time of the event. For removed volumes it is a snapshot,
+// and methods which act on the volume fail. The receiver must Close() the Volume.
+type VolumeEvent struct {
+	Type   VolumeEventType
+	Volume Volume
+}
+
+// volumeEventQuery selects creation and deletion events for MSFT_Volume, which WMI detects by polling
+// the volumes every 2 seconds. Modification events are not delivered.
+const volumeEventQuery = "SELECT * FROM __InstanceOperationEvent WITHIN 2 WHERE TargetInstance ISA 'MSFT_Volume'" +
+	" AND (__CLASS = '__InstanceCreationEvent' OR __CLASS = '__InstanceDeletionEvent')"
+
+// volumeEventWait is the time in milliseconds NextEvent waits for an event before the context and
+// the Service are checked again.
+const volumeEventWait = 250
+
+// wbemErrTimedOut is returned by SWbemEventSource.NextEvent if no event arrived in time.
+const wbemErrTimedOut = 0x80043001
+
+// volumeEventType maps the class of an intrinsic event to a VolumeEventType. Other events, such as
+// __InstanceModificationEvent, map to 0.
+func volumeEventType(class string) VolumeEventType {
+	switch class {
+	case "__InstanceCreationEvent":
+		return VolumeAdded
+	case "__InstanceDeletionEvent":
+		return VolumeRemoved
+	}
+	return 0
+}
+
+// isEventTimeout reports whether err is the timeout returned by NextEvent when no event arrived.
+func isEventTimeout(err error) bool {
+	var oleErr *ole.OleError
+	if !errors.As(err, &oleErr) {
+		return false
+	}
+	if uint32(oleErr.Code()) == wbemErrTimedOut {
+		return true
+	}
+	info, ok := oleErr.SubError().(ole.EXCEPINFO)
+	return ok && info.SCODE() == wbemErrTimedOut
+}
+
+// WatchVolumes subscribes to volume arrival and removal, and streams an event for each volume added
+// or removed. Events are detected within a few seconds of the change.
+//
+// The channel is closed, and the subscription released, when ctx is cancelled, the Service is closed
+// or the subscription fails. Waiting for events does not hold the Service lock, so the Service may be
+// used while watching.
+//
+// Example:
+//		events, err := svc.WatchVolumes(ctx)
+//		if err != nil {
+//			return err
+//		}
+//		for e := range events {
+//			logger.Infof("volume %s: %s", e.Type, e.Volume.Path)
+//			e.Volume.Close()
+//		}
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/wmisdk/swbemservices-execnotificationquery
+func (svc *Service) WatchVolumes(ctx context.Context) (<-chan VolumeEvent, error) {
+	unlock := svc.lock()
+	raw, err := svc.wmi().CallMethod(svc.wmiSvc, "ExecNotificationQuery", volumeEventQuery)
+	unlock()
+	if err != nil {
+		return nil, fmt.Errorf("ExecNotificationQuery(%s): %w", volumeEventQuery, err)
+	}
+	source := raw.ToIDispatch()
+
+	c := make(chan VolumeEvent)
+	svc.background(func() {
+		defer close(c)
+		defer source.Release()
+		for ctx.Err() == nil && !svc.closed() {
+			e, ok, err := svc.nextVolumeEvent(source)
+			if err != nil {
+				logger.Errorf("WatchVolumes: %v", err)
+				return
+			}
+			if !ok {
+				continue
+			}
+			select {
+			case c <- e:
+			case <-ctx.Done():
+				e.Volume.Close()
+				return
+			case <-svc.done:
+				e.Volume.Close()
+				return
+			}
+		}
+	})
+	return c, nil
+}
+
+// nextVolumeEvent waits briefly for the next event from source. It returns false if no volume was
+// added or removed in that time. Like other calls on objects, it does not take the Service lock;
+// source and the events it returns belong to the watching goroutine.
+func (svc *Service) nextVolumeEvent(source *ole.IDispatch) (VolumeEvent, bool, error) {
+	e := VolumeEvent{}
+	raw, err := svc.wmi().CallMethod(source, "NextEvent", int32(volumeEventWait))
+	if isEventTimeout(err) {
+		return e, false, nil
+	} else if err != nil {
+		return e, false, fmt.Errorf("NextEvent: %w", err)
+	}
+	event := raw.ToIDispatch()
+	defer event.Release()
+
+	class, err := svc.getProperty(event, "__CLASS")
+	if err != nil {
+		return e, false, err
+	}
+	if e.Type = volumeEventType(class.ToString()); e.Type == 0 {
+		return e, false, nil
+	}
+	target, err := svc.getProperty(event, "TargetInstance")
+	if err != nil {
+		return e, false, err
+	}
+	e.Volume = Volume{handle: target.ToIDispatch(), svc: svc}
+	if e.Volume.handle == nil {
+		return e, false, fmt.Errorf("NextEvent: event has no TargetInstance")
+	}
+	if err := e.Volume.QueryProperties(volumeProperties...); err != nil {
+		logger.Warningf("WatchVolumes: %v", err)
+	}
+	return e, true, nil
+}
@@ -0,0 +1,81 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-ole/go-ole"
+)
+
+func TestVolumeEventType(t *testing.T) {
+	tests := []struct {
+		in   string
+		want VolumeEventType
+	}{
+		{"__InstanceCreationEvent", VolumeAdded},
+		{"__InstanceDeletionEvent", VolumeRemoved},
+		{"__InstanceModificationEvent", 0},
+	}
+	for _, tt := range tests {
+		if got := volumeEventType(tt.in); got != tt.want {
+			t.Errorf("volumeEventType(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestIsEventTimeout(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{ole.NewError(wbemErrTimedOut), true},
+		{fmt.Errorf("NextEvent: %w", ole.NewError(wbemErrTimedOut)), true},
+		{ole.NewError(0x80041013), false},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		if got := isEventTimeout(tt.err); got != tt.want {
+			t.Errorf("isEventTimeout(%v) = %t, want %t", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestWatchVolumesClose(t *testing.T) {
+	f := &fakeWMI{methods: map[string]func(params ...interface{}) (*ole.VARIANT, error){
+		"ExecNotificationQuery": func(params ...interface{}) (*ole.VARIANT, error) {
+			if q := params[0].(string); !strings.Contains(q, "__InstanceCreationEvent") || !strings.Contains(q, "__InstanceDeletionEvent") {
+				t.Errorf("ExecNotificationQuery(%q) does not select only creation and deletion events", q)
+			}
+			return dispatchVariant(newFakeHandle()), nil
+		},
+		"NextEvent": func(params ...interface{}) (*ole.VARIANT, error) {
+			return nil, ole.NewError(wbemErrTimedOut)
+		},
+	}}
+	svc := &Service{caller: f, pending: &sync.WaitGroup{}, done: make(chan struct{}), doneOnce: &sync.Once{}}
+	events, err := svc.WatchVolumes(context.Background())
+	if err != nil {
+		t.Fatalf("WatchVolumes() returned %v", err)
+	}
+	svc.stopBackground()
+	if _, ok := <-events; ok {
+		t.Errorf("WatchVolumes() delivered an event after Close")
+	}
+}
@@ -0,0 +1,40 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build windows
+
+package storage
+
+import (
+	"fmt"
+
+	"github.com/google/glazier/go/helpers"
+	"github.com/google/winops/powershell"
+	"golang.org/x/sys/windows"
+)
+
+var (
+	fnExec  = helpers.Exec
+	fnPSCmd = powershell.Command
+)
+
+// isElevated reports whether the token of the current process is elevated.
+func isElevated() (bool, error) {
+	var t windows.Token
+	if err := windows.OpenProcessToken(windows.CurrentProcess(), windows.TOKEN_QUERY, &t); err != nil {
+		return false, fmt.Errorf("windows.OpenProcessToken: %w", err)
+	}
+	defer t.Close()
+	return t.IsElevated(), nil
+}
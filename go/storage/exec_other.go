@@ -0,0 +1,27 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !windows
+
+package storage
+
+// fnExec stands in for helpers.Exec, so the package builds for unit tests on other platforms.
+var fnExec = func(path string, args []string, conf interface{}) (interface{}, error) {
+	return nil, ErrNotSupported
+}
+
+// isElevated is not supported on this platform.
+func isElevated() (bool, error) {
+	return false, ErrNotSupported
+}
@@ -0,0 +1,121 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var propertyNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Filter builds a WQL WHERE clause from property comparisons joined with AND. String values are
+// quoted and escaped, so values taken from configuration cannot alter the query. Create one with
+// NewFilter.
+//
+// Example:
+//		f := storage.NewFilter().Equals("DriveLetter", "D").GreaterThan("Size", 1<<30)
+//		vset, err := svc.GetVolumesFiltered(f)
+type Filter struct {
+	conds []string
+	err   error
+}
+
+// NewFilter returns an empty Filter, which matches all objects.
+func NewFilter() Filter {
+	return Filter{}
+}
+
+// Equals adds a condition matching objects whose property equals value.
+func (f Filter) Equals(property string, value interface{}) Filter {
+	return f.add(property, "=", value)
+}
+
+// NotEquals adds a condition matching objects whose property does not equal value.
+func (f Filter) NotEquals(property string, value interface{}) Filter {
+	return f.add(property, "<>", value)
+}
+
+// GreaterThan adds a condition matching objects whose property is greater than value.
+func (f Filter) GreaterThan(property string, value interface{}) Filter {
+	return f.add(property, ">", value)
+}
+
+// LessThan adds a condition matching objects whose property is less than value.
+func (f Filter) LessThan(property string, value interface{}) Filter {
+	return f.add(property, "<", value)
+}
+
+// add returns a copy of f with an additional condition. Errors are kept until Build.
+func (f Filter) add(property, op string, value interface{}) Filter {
+	if f.err != nil {
+		return f
+	}
+	if !propertyNameRe.MatchString(property) {
+		f.err = fmt.Errorf("invalid property name %q", property)
+		return f
+	}
+	lit, err := wqlLiteral(value)
+	if err != nil {
+		f.err = fmt.Errorf("%s: %w", property, err)
+		return f
+	}
+	conds := make([]string, len(f.conds), len(f.conds)+1)
+	copy(conds, f.conds)
+	f.conds = append(conds, fmt.Sprintf("%s %s %s", property, op, lit))
+	return f
+}
+
+// Build returns the WHERE clause, or an empty string if the filter has no conditions.
+func (f Filter) Build() (string, error) {
+	if f.err != nil {
+		return "", f.err
+	}
+	if len(f.conds) == 0 {
+		return "", nil
+	}
+	return "WHERE " + strings.Join(f.conds, " AND "), nil
+}
+
+// wqlLiteral formats value as a WQL literal. Strings are quoted, with backslashes and quotes escaped.
+func wqlLiteral(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(v) + "'", nil
+	case bool:
+		if v {
+			return "TRUE", nil
+		}
+		return "FALSE", nil
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return fmt.Sprintf("%d", v), nil
+	}
+	return "", fmt.Errorf("unsupported value type %T", value)
+}
+
+// GetVolumesFiltered queries for volumes matching f.
+//
+// Close() must be called on the resulting VolumeSet to ensure all volumes are released.
+//
+// Example:
+//		svc.GetVolumesFiltered(storage.NewFilter().Equals("FileSystem", "NTFS"))
+func (svc *Service) GetVolumesFiltered(f Filter) (VolumeSet, error) {
+	filter, err := f.Build()
+	if err != nil {
+		return VolumeSet{}, fmt.Errorf("GetVolumesFiltered: %w", err)
+	}
+	return svc.GetVolumes(filter)
+}
@@ -0,0 +1,62 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"testing"
+)
+
+func TestFilterBuild(t *testing.T) {
+	tests := []struct {
+		desc    string
+		f       Filter
+		want    string
+		wantErr bool
+	}{
+		{"empty", NewFilter(), "", false},
+		{"string", NewFilter().Equals("DriveLetter", "D"), "WHERE DriveLetter = 'D'", false},
+		{
+			"combined",
+			NewFilter().Equals("FileSystem", "NTFS").GreaterThan("Size", 1<<30).NotEquals("IsReadOnly", true),
+			"WHERE FileSystem = 'NTFS' AND Size > 1073741824 AND IsReadOnly <> TRUE",
+			false,
+		},
+		{"escaped", NewFilter().Equals("FileSystemLabel", `it's a \ test`), `WHERE FileSystemLabel = 'it\'s a \\ test'`, false},
+		{"injection", NewFilter().Equals("DriveLetter", "D' OR '1'='1"), `WHERE DriveLetter = 'D\' OR \'1\'=\'1'`, false},
+		{"bad property", NewFilter().Equals("Size OR 1", 1), "", true},
+		{"bad value", NewFilter().LessThan("Size", 1.5), "", true},
+	}
+	for _, tt := range tests {
+		got, err := tt.f.Build()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: Build() returned error %v, want error: %t", tt.desc, err, tt.wantErr)
+		}
+		if got != tt.want {
+			t.Errorf("%s: Build() = %q, want %q", tt.desc, got, tt.want)
+		}
+	}
+}
+
+func TestFilterIsImmutable(t *testing.T) {
+	base := NewFilter().Equals("FileSystem", "NTFS")
+	a := base.Equals("DriveLetter", "C")
+	b := base.Equals("DriveLetter", "D")
+	if got, _ := a.Build(); got != "WHERE FileSystem = 'NTFS' AND DriveLetter = 'C'" {
+		t.Errorf("a.Build() = %q", got)
+	}
+	if got, _ := b.Build(); got != "WHERE FileSystem = 'NTFS' AND DriveLetter = 'D'" {
+		t.Errorf("b.Build() = %q", got)
+	}
+}
@@ -0,0 +1,469 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build windows
+
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	modkernel32          = windows.NewLazySystemDLL("kernel32.dll")
+	procGetDiskFreeSpace = modkernel32.NewProc("GetDiskFreeSpaceW")
+)
+
+// File system control codes.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/api/winioctl/
+const (
+	fsctlLockVolume              = 0x00090018
+	fsctlDismountVolume          = 0x00090020
+	fsctlIsVolumeDirty           = 0x00090078
+	fsctlSetSparse               = 0x000900c4
+	fsctlSetCompression          = 0x0009c040
+	fsctlSetIntegrityInformation = 0x0009c280
+	ioctlStorageQueryProperty    = 0x002d1400
+	ioctlDiskGetCacheInformation = 0x000740d4
+	ioctlDiskSetCacheInformation = 0x0007c0d8
+	ioctlStorageMediaRemoval     = 0x002d4804
+	ioctlStorageEjectMedia       = 0x002d4808
+)
+
+// STORAGE_PROPERTY_QUERY values.
+const (
+	storageDeviceTrimProperty = 8
+	propertyStandardQuery     = 0
+)
+
+// Checksum algorithms for FSCTL_SET_INTEGRITY_INFORMATION.
+const (
+	checksumTypeNone  = 0x0000
+	checksumTypeCRC64 = 0x0002
+)
+
+// Compression formats for FSCTL_SET_COMPRESSION.
+const (
+	compressionFormatNone    = 0x0000
+	compressionFormatDefault = 0x0001
+)
+
+// volumeIsDirty is set in the FSCTL_IS_VOLUME_DIRTY output when the dirty bit is set.
+const volumeIsDirty = 0x00000001
+
+// openVolume opens a handle to the volume device at path, which may be a volume GUID path
+// (\\?\Volume{GUID}\) or a drive letter (C:). The handle must be closed by the caller.
+func openVolume(path string, access uint32) (windows.Handle, error) {
+	dev := strings.TrimSuffix(path, `\`)
+	if len(dev) == 2 && dev[1] == ':' {
+		dev = `\\.\` + dev
+	}
+	p, err := windows.UTF16PtrFromString(dev)
+	if err != nil {
+		return windows.InvalidHandle, fmt.Errorf("windows.UTF16PtrFromString(%s): %w", dev, err)
+	}
+	h, err := windows.CreateFile(p, access, windows.FILE_SHARE_READ|windows.FILE_SHARE_WRITE, nil, windows.OPEN_EXISTING, 0, 0)
+	if err != nil {
+		return windows.InvalidHandle, fmt.Errorf("windows.CreateFile(%s): %w", dev, err)
+	}
+	return h, nil
+}
+
+// openDisk opens a handle to the physical disk with the given number. The handle must be closed by
+// the caller.
+func openDisk(number int32, access uint32) (windows.Handle, error) {
+	dev := fmt.Sprintf(`\\.\PhysicalDrive%d`, number)
+	p, err := windows.UTF16PtrFromString(dev)
+	if err != nil {
+		return windows.InvalidHandle, fmt.Errorf("windows.UTF16PtrFromString(%s): %w", dev, err)
+	}
+	h, err := windows.CreateFile(p, access, windows.FILE_SHARE_READ|windows.FILE_SHARE_WRITE, nil, windows.OPEN_EXISTING, 0, 0)
+	if err != nil {
+		return windows.InvalidHandle, fmt.Errorf("windows.CreateFile(%s): %w", dev, err)
+	}
+	return h, nil
+}
+
+// openVolumeRoot opens a handle to the root directory of the volume at path. The handle must be
+// closed by the caller.
+func openVolumeRoot(path string, access uint32) (windows.Handle, error) {
+	root := path
+	if !strings.HasSuffix(root, `\`) {
+		root += `\`
+	}
+	p, err := windows.UTF16PtrFromString(root)
+	if err != nil {
+		return windows.InvalidHandle, fmt.Errorf("windows.UTF16PtrFromString(%s): %w", root, err)
+	}
+	h, err := windows.CreateFile(p, access, windows.FILE_SHARE_READ|windows.FILE_SHARE_WRITE|windows.FILE_SHARE_DELETE,
+		nil, windows.OPEN_EXISTING, windows.FILE_FLAG_BACKUP_SEMANTICS, 0)
+	if err != nil {
+		return windows.InvalidHandle, fmt.Errorf("windows.CreateFile(%s): %w", root, err)
+	}
+	return h, nil
+}
+
+// setIntegrity enables or disables integrity streams on the root directory of the volume at path.
+// New files and directories inherit the setting from their parent.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/api/winioctl/ni-winioctl-fsctl_set_integrity_information
+func setIntegrity(path string, enable bool) error {
+	h, err := openVolumeRoot(path, windows.GENERIC_READ|windows.GENERIC_WRITE)
+	if err != nil {
+		return err
+	}
+	defer windows.CloseHandle(h)
+
+	// FSCTL_SET_INTEGRITY_INFORMATION_BUFFER: WORD ChecksumAlgorithm, WORD Reserved, DWORD Flags
+	in := make([]byte, 8)
+	alg := uint16(checksumTypeNone)
+	if enable {
+		alg = checksumTypeCRC64
+	}
+	binary.LittleEndian.PutUint16(in[0:], alg)
+	var returned uint32
+	if err := windows.DeviceIoControl(h, fsctlSetIntegrityInformation, &in[0], uint32(len(in)), nil, 0, &returned, nil); err != nil {
+		return fmt.Errorf("DeviceIoControl(FSCTL_SET_INTEGRITY_INFORMATION): %w", err)
+	}
+	return nil
+}
+
+// setCompression enables or disables NTFS compression on the root directory of the volume at path.
+// New files and directories inherit the setting from their parent.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/api/winioctl/ni-winioctl-fsctl_set_compression
+func setCompression(path string, enable bool) error {
+	h, err := openVolumeRoot(path, windows.GENERIC_READ|windows.GENERIC_WRITE)
+	if err != nil {
+		return err
+	}
+	defer windows.CloseHandle(h)
+
+	in := make([]byte, 2)
+	format := uint16(compressionFormatNone)
+	if enable {
+		format = compressionFormatDefault
+	}
+	binary.LittleEndian.PutUint16(in, format)
+	var returned uint32
+	if err := windows.DeviceIoControl(h, fsctlSetCompression, &in[0], uint32(len(in)), nil, 0, &returned, nil); err != nil {
+		return fmt.Errorf("DeviceIoControl(FSCTL_SET_COMPRESSION): %w", err)
+	}
+	return nil
+}
+
+// setSparse sets or clears the sparse attribute of the file at path. Ranges of a sparse file that
+// are zeroed with FSCTL_SET_ZERO_DATA, or never written, do not have disk space allocated.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/api/winioctl/ni-winioctl-fsctl_set_sparse
+func setSparse(path string, sparse bool) error {
+	p, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return fmt.Errorf("windows.UTF16PtrFromString(%s): %w", path, err)
+	}
+	h, err := windows.CreateFile(p, windows.GENERIC_READ|windows.GENERIC_WRITE, windows.FILE_SHARE_READ|windows.FILE_SHARE_WRITE|windows.FILE_SHARE_DELETE,
+		nil, windows.OPEN_EXISTING, 0, 0)
+	if err != nil {
+		return fmt.Errorf("windows.CreateFile(%s): %w", path, err)
+	}
+	defer windows.CloseHandle(h)
+
+	// FILE_SET_SPARSE_BUFFER: BOOLEAN SetSparse
+	in := []byte{0}
+	if sparse {
+		in[0] = 1
+	}
+	var returned uint32
+	if err := windows.DeviceIoControl(h, fsctlSetSparse, &in[0], uint32(len(in)), nil, 0, &returned, nil); err != nil {
+		return fmt.Errorf("DeviceIoControl(FSCTL_SET_SPARSE): %w", err)
+	}
+	return nil
+}
+
+// volumeDirty reports whether the dirty bit is set on the volume at path.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/api/winioctl/ni-winioctl-fsctl_is_volume_dirty
+func volumeDirty(path string) (bool, error) {
+	h, err := openVolume(path, windows.GENERIC_READ)
+	if err != nil {
+		return false, err
+	}
+	defer windows.CloseHandle(h)
+
+	out := make([]byte, 4)
+	var returned uint32
+	if err := windows.DeviceIoControl(h, fsctlIsVolumeDirty, nil, 0, &out[0], uint32(len(out)), &returned, nil); err != nil {
+		return false, fmt.Errorf("DeviceIoControl(FSCTL_IS_VOLUME_DIRTY): %w", err)
+	}
+	return binary.LittleEndian.Uint32(out)&volumeIsDirty != 0, nil
+}
+
+// dismountVolume dismounts the file system on the volume at path. The volume is locked first, which
+// fails with ErrVolumeInUse while other handles are open, unless force is set, in which case the
+// volume is dismounted regardless and open handles are invalidated.
+//
+// The volume is mounted again automatically on next access.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/api/winioctl/ni-winioctl-fsctl_dismount_volume
+func dismountVolume(path string, force bool) error {
+	h, err := openVolume(path, windows.GENERIC_READ|windows.GENERIC_WRITE)
+	if err != nil {
+		return err
+	}
+	defer windows.CloseHandle(h)
+
+	var returned uint32
+	if err := windows.DeviceIoControl(h, fsctlLockVolume, nil, 0, nil, 0, &returned, nil); err != nil {
+		if !errors.Is(err, windows.ERROR_ACCESS_DENIED) {
+			return fmt.Errorf("DeviceIoControl(FSCTL_LOCK_VOLUME): %w", err)
+		}
+		if !force {
+			return fmt.Errorf("DeviceIoControl(FSCTL_LOCK_VOLUME): %w", ErrVolumeInUse)
+		}
+	}
+	if err := windows.DeviceIoControl(h, fsctlDismountVolume, nil, 0, nil, 0, &returned, nil); err != nil {
+		return fmt.Errorf("DeviceIoControl(FSCTL_DISMOUNT_VOLUME): %w", err)
+	}
+	return nil
+}
+
+// trimEnabled reports whether the device backing the volume at path supports TRIM.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/api/winioctl/ns-winioctl-device_trim_descriptor
+func trimEnabled(path string) (bool, error) {
+	// IOCTL_STORAGE_QUERY_PROPERTY does not require read or write access.
+	h, err := openVolume(path, 0)
+	if err != nil {
+		return false, err
+	}
+	defer windows.CloseHandle(h)
+
+	// STORAGE_PROPERTY_QUERY: DWORD PropertyId, DWORD QueryType, BYTE AdditionalParameters[1]
+	in := make([]byte, 12)
+	binary.LittleEndian.PutUint32(in[0:], storageDeviceTrimProperty)
+	binary.LittleEndian.PutUint32(in[4:], propertyStandardQuery)
+	// DEVICE_TRIM_DESCRIPTOR: DWORD Version, DWORD Size, BOOLEAN TrimEnabled
+	out := make([]byte, 12)
+	var returned uint32
+	if err := windows.DeviceIoControl(h, ioctlStorageQueryProperty, &in[0], uint32(len(in)), &out[0], uint32(len(out)), &returned, nil); err != nil {
+		return false, fmt.Errorf("DeviceIoControl(IOCTL_STORAGE_QUERY_PROPERTY): %w", err)
+	}
+	return returned > 8 && out[8] != 0, nil
+}
+
+// volumeFlags returns the file system flags of the volume whose root directory is root.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/api/fileapi/nf-fileapi-getvolumeinformationw
+func volumeFlags(root string) (uint32, error) {
+	p, err := windows.UTF16PtrFromString(root)
+	if err != nil {
+		return 0, fmt.Errorf("windows.UTF16PtrFromString(%s): %w", root, err)
+	}
+	var flags uint32
+	if err := windows.GetVolumeInformation(p, nil, 0, nil, nil, &flags, nil, 0); err != nil {
+		return 0, fmt.Errorf("windows.GetVolumeInformation(%s): %w", root, err)
+	}
+	return flags, nil
+}
+
+// diskCacheInfoSize is the size of DISK_CACHE_INFORMATION, and diskCacheWriteEnabled the offset of
+// its WriteCacheEnabled member.
+const (
+	diskCacheInfoSize     = 24
+	diskCacheWriteEnabled = 2
+)
+
+// diskCacheInformation reads the cache settings of the disk open at h, returning ErrNotSupported if the
+// disk does not report them.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/api/winioctl/ni-winioctl-ioctl_disk_get_cache_information
+func diskCacheInformation(h windows.Handle) ([]byte, error) {
+	info := make([]byte, diskCacheInfoSize)
+	var returned uint32
+	if err := windows.DeviceIoControl(h, ioctlDiskGetCacheInformation, nil, 0, &info[0], uint32(len(info)), &returned, nil); err != nil {
+		if errors.Is(err, windows.ERROR_INVALID_FUNCTION) || errors.Is(err, windows.ERROR_NOT_SUPPORTED) {
+			return nil, fmt.Errorf("DeviceIoControl(IOCTL_DISK_GET_CACHE_INFORMATION): %w", ErrNotSupported)
+		}
+		return nil, fmt.Errorf("DeviceIoControl(IOCTL_DISK_GET_CACHE_INFORMATION): %w", err)
+	}
+	return info, nil
+}
+
+// writeCacheEnabled reports whether the write cache of disk number is enabled.
+func writeCacheEnabled(number int32) (bool, error) {
+	h, err := openDisk(number, windows.GENERIC_READ)
+	if err != nil {
+		return false, err
+	}
+	defer windows.CloseHandle(h)
+
+	info, err := diskCacheInformation(h)
+	if err != nil {
+		return false, err
+	}
+	return info[diskCacheWriteEnabled] != 0, nil
+}
+
+// setWriteCacheEnabled enables or disables the write cache of disk number, leaving its other cache
+// settings unchanged.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/api/winioctl/ni-winioctl-ioctl_disk_set_cache_information
+func setWriteCacheEnabled(number int32, enable bool) error {
+	h, err := openDisk(number, windows.GENERIC_READ|windows.GENERIC_WRITE)
+	if err != nil {
+		return err
+	}
+	defer windows.CloseHandle(h)
+
+	info, err := diskCacheInformation(h)
+	if err != nil {
+		return err
+	}
+	info[diskCacheWriteEnabled] = 0
+	if enable {
+		info[diskCacheWriteEnabled] = 1
+	}
+	var returned uint32
+	if err := windows.DeviceIoControl(h, ioctlDiskSetCacheInformation, &info[0], uint32(len(info)), nil, 0, &returned, nil); err != nil {
+		if errors.Is(err, windows.ERROR_INVALID_FUNCTION) || errors.Is(err, windows.ERROR_NOT_SUPPORTED) {
+			return fmt.Errorf("DeviceIoControl(IOCTL_DISK_SET_CACHE_INFORMATION): %w", ErrNotSupported)
+		}
+		return fmt.Errorf("DeviceIoControl(IOCTL_DISK_SET_CACHE_INFORMATION): %w", err)
+	}
+	return nil
+}
+
+// ejectMedia dismounts the volume at path and ejects its media. It fails with ErrVolumeInUse if the
+// volume has open handles.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/api/winioctl/ni-winioctl-ioctl_storage_eject_media
+func ejectMedia(path string) error {
+	h, err := openVolume(path, windows.GENERIC_READ|windows.GENERIC_WRITE)
+	if err != nil {
+		return err
+	}
+	defer windows.CloseHandle(h)
+
+	var returned uint32
+	if err := windows.DeviceIoControl(h, fsctlLockVolume, nil, 0, nil, 0, &returned, nil); err != nil {
+		if errors.Is(err, windows.ERROR_ACCESS_DENIED) {
+			return fmt.Errorf("DeviceIoControl(FSCTL_LOCK_VOLUME): %w", ErrVolumeInUse)
+		}
+		return fmt.Errorf("DeviceIoControl(FSCTL_LOCK_VOLUME): %w", err)
+	}
+	if err := windows.DeviceIoControl(h, fsctlDismountVolume, nil, 0, nil, 0, &returned, nil); err != nil {
+		return fmt.Errorf("DeviceIoControl(FSCTL_DISMOUNT_VOLUME): %w", err)
+	}
+	// PREVENT_MEDIA_REMOVAL: BOOLEAN PreventMediaRemoval
+	allow := []byte{0}
+	if err := windows.DeviceIoControl(h, ioctlStorageMediaRemoval, &allow[0], uint32(len(allow)), nil, 0, &returned, nil); err != nil {
+		return fmt.Errorf("DeviceIoControl(IOCTL_STORAGE_MEDIA_REMOVAL): %w", err)
+	}
+	if err := windows.DeviceIoControl(h, ioctlStorageEjectMedia, nil, 0, nil, 0, &returned, nil); err != nil {
+		return fmt.Errorf("DeviceIoControl(IOCTL_STORAGE_EJECT_MEDIA): %w", err)
+	}
+	return nil
+}
+
+// mountPoints returns the full paths of the mount points hosted on the volume whose root directory
+// is root, including those whose target volume no longer exists.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/api/winbase/nf-winbase-findfirstvolumemountpointw
+func mountPoints(root string) ([]string, error) {
+	p, err := windows.UTF16PtrFromString(root)
+	if err != nil {
+		return nil, fmt.Errorf("windows.UTF16PtrFromString(%s): %w", root, err)
+	}
+	buf := make([]uint16, windows.MAX_PATH)
+	h, err := windows.FindFirstVolumeMountPoint(p, &buf[0], uint32(len(buf)))
+	if err != nil {
+		if errors.Is(err, windows.ERROR_NO_MORE_FILES) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("windows.FindFirstVolumeMountPoint(%s): %w", root, err)
+	}
+	defer windows.FindVolumeMountPointClose(h)
+
+	var paths []string
+	for {
+		paths = append(paths, root+windows.UTF16ToString(buf))
+		if err := windows.FindNextVolumeMountPoint(h, &buf[0], uint32(len(buf))); err != nil {
+			if errors.Is(err, windows.ERROR_NO_MORE_FILES) {
+				return paths, nil
+			}
+			return paths, fmt.Errorf("windows.FindNextVolumeMountPoint(%s): %w", root, err)
+		}
+	}
+}
+
+// mountPointTarget returns the volume GUID path of the volume mounted at path.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/api/fileapi/nf-fileapi-getvolumenameforvolumemountpointw
+func mountPointTarget(path string) (string, error) {
+	p, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return "", fmt.Errorf("windows.UTF16PtrFromString(%s): %w", path, err)
+	}
+	buf := make([]uint16, windows.MAX_PATH)
+	if err := windows.GetVolumeNameForVolumeMountPoint(p, &buf[0], uint32(len(buf))); err != nil {
+		return "", fmt.Errorf("windows.GetVolumeNameForVolumeMountPoint(%s): %w", path, err)
+	}
+	return windows.UTF16ToString(buf), nil
+}
+
+// isOrphanedMountPoint reports whether err, returned by mountPointTarget, indicates that the volume
+// the mount point refers to no longer exists, rather than a failure to resolve the mount point.
+func isOrphanedMountPoint(err error) bool {
+	return errors.Is(err, windows.ERROR_FILE_NOT_FOUND) || errors.Is(err, windows.ERROR_PATH_NOT_FOUND) ||
+		errors.Is(err, windows.ERROR_INVALID_PARAMETER)
+}
+
+// deleteMountPoint removes the mount point at path.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/api/fileapi/nf-fileapi-deletevolumemountpointw
+func deleteMountPoint(path string) error {
+	p, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return fmt.Errorf("windows.UTF16PtrFromString(%s): %w", path, err)
+	}
+	if err := windows.DeleteVolumeMountPoint(p); err != nil {
+		return fmt.Errorf("windows.DeleteVolumeMountPoint(%s): %w", path, err)
+	}
+	return nil
+}
+
+// clusterSize returns the bytes per cluster of the volume whose root directory is root.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/api/fileapi/nf-fileapi-getdiskfreespacew
+func clusterSize(root string) (uint32, error) {
+	p, err := windows.UTF16PtrFromString(root)
+	if err != nil {
+		return 0, fmt.Errorf("windows.UTF16PtrFromString(%s): %w", root, err)
+	}
+	var sectorsPerCluster, bytesPerSector, freeClusters, totalClusters uint32
+	r, _, err := procGetDiskFreeSpace.Call(uintptr(unsafe.Pointer(p)),
+		uintptr(unsafe.Pointer(&sectorsPerCluster)), uintptr(unsafe.Pointer(&bytesPerSector)),
+		uintptr(unsafe.Pointer(&freeClusters)), uintptr(unsafe.Pointer(&totalClusters)))
+	if r == 0 {
+		return 0, fmt.Errorf("GetDiskFreeSpace(%s): %w", root, err)
+	}
+	return sectorsPerCluster * bytesPerSector, nil
+}
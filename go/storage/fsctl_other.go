@@ -0,0 +1,80 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !windows
+
+package storage
+
+// The Win32 file system and device calls are not available on this platform, so the methods built on
+// them fail with ErrNotSupported.
+
+func setIntegrity(path string, enable bool) error {
+	return ErrNotSupported
+}
+
+func setCompression(path string, enable bool) error {
+	return ErrNotSupported
+}
+
+func setSparse(path string, sparse bool) error {
+	return ErrNotSupported
+}
+
+func volumeDirty(path string) (bool, error) {
+	return false, ErrNotSupported
+}
+
+func dismountVolume(path string, force bool) error {
+	return ErrNotSupported
+}
+
+func trimEnabled(path string) (bool, error) {
+	return false, ErrNotSupported
+}
+
+func volumeFlags(root string) (uint32, error) {
+	return 0, ErrNotSupported
+}
+
+func writeCacheEnabled(number int32) (bool, error) {
+	return false, ErrNotSupported
+}
+
+func setWriteCacheEnabled(number int32, enable bool) error {
+	return ErrNotSupported
+}
+
+func ejectMedia(path string) error {
+	return ErrNotSupported
+}
+
+func mountPoints(root string) ([]string, error) {
+	return nil, ErrNotSupported
+}
+
+func mountPointTarget(path string) (string, error) {
+	return "", ErrNotSupported
+}
+
+func isOrphanedMountPoint(err error) bool {
+	return false
+}
+
+func deleteMountPoint(path string) error {
+	return ErrNotSupported
+}
+
+func clusterSize(root string) (uint32, error) {
+	return 0, ErrNotSupported
+}
@@ -15,11 +15,14 @@
 package storage
 
 import (
+	"context"
 	"fmt"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/google/logger"
 	"github.com/go-ole/go-ole"
-	"github.com/go-ole/go-ole/oleutil"
 )
 
 // Partition represents a MSFT_Partition object.
@@ -32,6 +35,7 @@ type Partition struct {
 	AccessPaths          string
 	OperationalStatus    int32
 	TransitionState      int32
+	Offset               uint64
 	Size                 uint64
 	MbrType              int32
 	GptType              string
@@ -45,7 +49,117 @@ type Partition struct {
 	IsShadowCopy         bool
 	NoDefaultDriveLetter bool
 
+	// AllowSystemDisk permits destructive operations on this partition while system disk protection is enabled.
+	AllowSystemDisk bool
+
 	handle *ole.IDispatch
+	svc    *Service
+}
+
+// checkSystemDisk returns ErrSystemDisk if system disk protection applies to the partition and the
+// partition resides on the disk hosting the running OS.
+func (p *Partition) checkSystemDisk() error {
+	if p.AllowSystemDisk || p.svc == nil || !enabled(p.svc.protectSystemDisk) {
+		return nil
+	}
+	if p.IsSystem || p.IsBoot {
+		return fmt.Errorf("partition %d on disk %d: %w", p.PartitionNumber, p.DiskNumber, ErrSystemDisk)
+	}
+
+	d, err := p.disk()
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.checkSystemDisk()
+}
+
+// disk returns the disk the partition resides on.
+//
+// Close() must be called on the resulting Disk.
+func (p *Partition) disk() (Disk, error) {
+	d := Disk{svc: p.svc}
+	h, err := p.svc.associator(p.handle, "MSFT_DiskToPartition", "MSFT_Disk")
+	if err != nil {
+		return d, err
+	}
+	d.handle = h
+	return d, d.Query()
+}
+
+// accessPaths returns all access paths of the partition, including its drive letter, mount point
+// folders and volume GUID path.
+func (p *Partition) accessPaths() ([]string, error) {
+	prop, err := p.svc.getProperty(p.handle, "AccessPaths")
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	if arr := prop.ToArray(); arr != nil {
+		for _, v := range arr.ToValueArray() {
+			if s, ok := v.(string); ok {
+				paths = append(paths, s)
+			}
+		}
+	}
+	return paths, nil
+}
+
+// Type returns a short name for the partition type, similar to the Type column shown by
+// Get-Partition: "System", "Reserved", "Basic", "Recovery" or an LDM type for GPT partitions, and the
+// MBR type names (such as "IFS" or "FAT32") for MBR partitions. Unrecognized types are reported as
+// "Unknown" with the raw type.
+func (p *Partition) Type() string {
+	if p.GptType != "" {
+		switch GptType(strings.ToLower(p.GptType)) {
+		case GptTypes.SystemPartition:
+			return "System"
+		case GptTypes.MicrosoftReserved:
+			return "Reserved"
+		case GptTypes.BasicData:
+			return "Basic"
+		case GptTypes.MicrosoftRecovery:
+			return "Recovery"
+		case GptTypes.LDMMetadata:
+			return "LDM Metadata"
+		case GptTypes.LDMData:
+			return "LDM Data"
+		}
+		return fmt.Sprintf("Unknown(%s)", p.GptType)
+	}
+	switch MbrType(p.MbrType) {
+	case MbrTypes.FAT12:
+		return "FAT12"
+	case MbrTypes.FAT16:
+		return "FAT16"
+	case MbrTypes.Extended:
+		return "Extended"
+	case MbrTypes.Huge:
+		return "Huge"
+	case MbrTypes.IFS:
+		return "IFS"
+	case MbrTypes.FAT32:
+		return "FAT32"
+	case MbrTypes.LDM:
+		return "LDM"
+	}
+	return fmt.Sprintf("Unknown(%d)", p.MbrType)
+}
+
+// isLDM reports whether the partition is a Logical Disk Manager (LDM) partition, which only exist on
+// dynamic disks.
+func (p *Partition) isLDM() bool {
+	if p.MbrType == int32(MbrTypes.LDM) {
+		return true
+	}
+	return strings.EqualFold(p.GptType, string(GptTypes.LDMMetadata)) || strings.EqualFold(p.GptType, string(GptTypes.LDMData))
+}
+
+// warnIfDynamic logs a warning if a destructive operation is about to run against an LDM partition.
+func (p *Partition) warnIfDynamic(op string) {
+	if p.isLDM() {
+		logger.Warningf("%s: partition %d on disk %d is a dynamic (LDM) partition; operations may not behave as on a basic disk", op, p.PartitionNumber, p.DiskNumber)
+	}
 }
 
 // Close releases the handle to the partition.
@@ -57,16 +171,35 @@ func (p *Partition) Close() {
 
 // Delete attempts to delete a partition.
 //
+// Delete refuses to delete the system or boot partition, returning ErrSystemPartition, unless
+// AllowSystemDisk is set on the partition. This applies whether or not system disk protection is enabled.
+//
+// Example:
+//		p.AllowSystemDisk = true // the system partition is being replaced
+//		p.Delete()
+//
 // Ref: https://docs.microsoft.com/en-us/previous-versions/windows/desktop/stormgmt/msft-partition-deleteobject
 func (p *Partition) Delete() (ExtendedStatus, error) {
+	defer p.svc.timeCall("Partition.Delete")()
 	stat := ExtendedStatus{}
+	if (p.IsSystem || p.IsBoot) && !p.AllowSystemDisk {
+		return stat, fmt.Errorf("partition %d on disk %d: %w", p.PartitionNumber, p.DiskNumber, ErrSystemPartition)
+	}
+	if err := p.checkSystemDisk(); err != nil {
+		return stat, err
+	}
+	if p.svc.skipDryRun("Partition.Delete(disk %d, partition %d)", p.DiskNumber, p.PartitionNumber) {
+		return stat, nil
+	}
+	p.warnIfDynamic("Delete")
 	var extendedStatus ole.VARIANT
 	ole.VariantInit(&extendedStatus)
-	resultRaw, err := oleutil.CallMethod(p.handle, "DeleteObject", &extendedStatus)
+	resultRaw, err := p.svc.wmi().CallMethod(p.handle, "DeleteObject", &extendedStatus)
+	stat = p.svc.newExtendedStatus(&extendedStatus)
 	if err != nil {
 		return stat, fmt.Errorf("DeleteObject: %w", err)
 	} else if val, ok := resultRaw.Value().(int32); val != 0 || !ok {
-		return stat, fmt.Errorf("error code returned during deletion: %d", val)
+		return stat, &StorageError{"deletion", val}
 	}
 	return stat, nil
 }
@@ -81,11 +214,12 @@ func (p *Partition) Offline() (ExtendedStatus, error) {
 	stat := ExtendedStatus{}
 	var extendedStatus ole.VARIANT
 	ole.VariantInit(&extendedStatus)
-	res, err := oleutil.CallMethod(p.handle, "Offline", &extendedStatus)
+	res, err := p.svc.wmi().CallMethod(p.handle, "Offline", &extendedStatus)
+	stat = p.svc.newExtendedStatus(&extendedStatus)
 	if err != nil {
 		return stat, fmt.Errorf("Offline(): %w", err)
 	} else if val, ok := res.Value().(int32); val != 0 || !ok {
-		return stat, fmt.Errorf("error code returned during offline: %d", val)
+		return stat, &StorageError{"offline", val}
 	}
 	return stat, nil
 }
@@ -100,11 +234,12 @@ func (p *Partition) Online() (ExtendedStatus, error) {
 	stat := ExtendedStatus{}
 	var extendedStatus ole.VARIANT
 	ole.VariantInit(&extendedStatus)
-	res, err := oleutil.CallMethod(p.handle, "Online", &extendedStatus)
+	res, err := p.svc.wmi().CallMethod(p.handle, "Online", &extendedStatus)
+	stat = p.svc.newExtendedStatus(&extendedStatus)
 	if err != nil {
 		return stat, fmt.Errorf("Online(): %w", err)
 	} else if val, ok := res.Value().(int32); val != 0 || !ok {
-		return stat, fmt.Errorf("error code returned during online: %d", val)
+		return stat, &StorageError{"online", val}
 	}
 	return stat, nil
 }
@@ -117,7 +252,14 @@ func (p *Partition) Online() (ExtendedStatus, error) {
 // Example: Automatically assign the next available Drive Letter:
 //		p.AddAccessPath("", true)
 //
-// Note: You cannot specify both a valid drive letter and auto assignment as true together.
+// Example: Mount the partition at an empty NTFS folder:
+//		p.AddAccessPath(`C:\mnt\data\`, false)
+//
+// Note: You cannot specify both a valid drive letter and auto assignment as true together. When
+// autoAssign is set, accessPath is ignored.
+//
+// On success the partition is re-queried, so DriveLetter and AccessPaths reflect the new assignment,
+// including the letter chosen by the system when autoAssign is set.
 //
 // Ref: https://docs.microsoft.com/en-us/previous-versions/windows/desktop/stormgmt/addaccesspath-msft-partition
 func (p *Partition) AddAccessPath(accessPath string, autoAssign bool) (ExtendedStatus, error) {
@@ -127,19 +269,21 @@ func (p *Partition) AddAccessPath(accessPath string, autoAssign bool) (ExtendedS
 	var resultRaw *ole.VARIANT
 	var err error
 	if autoAssign {
-		resultRaw, err = oleutil.CallMethod(p.handle, "AddAccessPath", nil, autoAssign, &extendedStatus)
+		resultRaw, err = p.svc.wmi().CallMethod(p.handle, "AddAccessPath", nil, autoAssign, &extendedStatus)
 	} else {
-		resultRaw, err = oleutil.CallMethod(p.handle, "AddAccessPath", accessPath, nil, &extendedStatus)
+		resultRaw, err = p.svc.wmi().CallMethod(p.handle, "AddAccessPath", accessPath, nil, &extendedStatus)
 	}
+	stat = p.svc.newExtendedStatus(&extendedStatus)
 	if err != nil {
 		return stat, fmt.Errorf("AddAccessPath: %w", err)
 	} else if val, ok := resultRaw.Value().(int32); val != 0 || !ok {
-		return stat, fmt.Errorf("error code returned during AddAccessPath: %d", val)
+		return stat, &StorageError{"AddAccessPath", val}
 	}
-	return stat, nil
+	return stat, p.requery()
 }
 
-// RemoveAccessPath removes the access path from the partition.
+// RemoveAccessPath removes the access path, a drive letter or mount folder, from the partition. On
+// success the partition is re-queried, so DriveLetter and AccessPaths reflect the change.
 //
 // Example: Remove the driveLetter of D: from a partition
 //		p.RemoveAccessPath("D:")
@@ -147,49 +291,65 @@ func (p *Partition) AddAccessPath(accessPath string, autoAssign bool) (ExtendedS
 // Ref: https://docs.microsoft.com/en-us/previous-versions/windows/desktop/stormgmt/removeaccesspath-msft-partition
 func (p *Partition) RemoveAccessPath(accessPath string) (ExtendedStatus, error) {
 	stat := ExtendedStatus{}
+	if p.svc.skipDryRun("Partition.RemoveAccessPath(disk %d, partition %d, %s)", p.DiskNumber, p.PartitionNumber, accessPath) {
+		return stat, nil
+	}
 	var extendedStatus ole.VARIANT
 	ole.VariantInit(&extendedStatus)
-	resultRaw, err := oleutil.CallMethod(p.handle, "RemoveAccessPath", accessPath, &extendedStatus)
+	resultRaw, err := p.svc.wmi().CallMethod(p.handle, "RemoveAccessPath", accessPath, &extendedStatus)
+	stat = p.svc.newExtendedStatus(&extendedStatus)
 	if err != nil {
 		return stat, fmt.Errorf("RemoveAccessPath: %w", err)
 	} else if val, ok := resultRaw.Value().(int32); val != 0 || !ok {
-		return stat, fmt.Errorf("error code returned during RemoveAccessPath: %d", val)
+		return stat, &StorageError{"RemoveAccessPath", val}
 	}
-	return stat, nil
+	return stat, p.requery()
+}
+
+// requery refreshes the partition's snapshot and reads it again, after a change made through it.
+func (p *Partition) requery() error {
+	if err := p.svc.refreshObject(p.handle); err != nil {
+		return err
+	}
+	return p.Query()
 }
 
 // Query reads and populates the partition state.
 func (p *Partition) Query() error {
+	defer p.svc.timeCall("Partition.Query")()
 	if p.handle == nil {
 		return fmt.Errorf("invalid handle")
 	}
 
 	// DriveLetter
-	prop, err := oleutil.GetProperty(p.handle, "DriveLetter")
+	prop, err := p.svc.getProperty(p.handle, "DriveLetter")
 	if err != nil {
-		return fmt.Errorf("oleutil.GetProperty(DriveLetter): %w", err)
+		return err
+	}
+	// DriveLetter is represented as Char16 (Ascii), and is null for partitions without a letter
+	p.DriveLetter = ""
+	if prop.Val != 0 {
+		p.DriveLetter = string(rune(prop.Val))
 	}
-	// DriveLetter is represented as Char16 (Ascii)
-	p.DriveLetter = string(rune(prop.Val))
 
 	// AccessPaths
-	prop, err = oleutil.GetProperty(p.handle, "AccessPaths")
+	prop, err = p.svc.getProperty(p.handle, "AccessPaths")
 	if err != nil {
-		return fmt.Errorf("oleutil.GetProperty(AccessPaths): %w", err)
+		return err
 	}
 	p.AccessPaths = prop.ToString()
 
 	// GptType
-	prop, err = oleutil.GetProperty(p.handle, "GptType")
+	prop, err = p.svc.getProperty(p.handle, "GptType")
 	if err != nil {
-		return fmt.Errorf("oleutil.GetProperty(GptType): %w", err)
+		return err
 	}
 	p.GptType = prop.ToString()
 
 	// GUID
-	prop, err = oleutil.GetProperty(p.handle, "Guid")
+	prop, err = p.svc.getProperty(p.handle, "Guid")
 	if err != nil {
-		return fmt.Errorf("oleutil.GetProperty(Guid): %w", err)
+		return err
 	}
 	p.GUID = prop.ToString()
 
@@ -199,6 +359,7 @@ func (p *Partition) Query() error {
 		[]interface{}{"PartitionNumber", &p.PartitionNumber},
 		[]interface{}{"OperationalStatus", &p.OperationalStatus},
 		[]interface{}{"TransitionState", &p.TransitionState},
+		[]interface{}{"Offset", &p.Offset},
 		[]interface{}{"Size", &p.Size},
 		[]interface{}{"MbrType", &p.MbrType},
 		[]interface{}{"IsReadOnly", &p.IsReadOnly},
@@ -210,9 +371,9 @@ func (p *Partition) Query() error {
 		[]interface{}{"IsShadowCopy", &p.IsShadowCopy},
 		[]interface{}{"NoDefaultDriveLetter", &p.NoDefaultDriveLetter},
 	} {
-		val, err := oleutil.GetProperty(p.handle, prop[0].(string))
+		val, err := p.svc.getProperty(p.handle, prop[0].(string))
 		if err != nil {
-			return fmt.Errorf("oleutil.GetProperty(%s): %w", prop[0].(string), err)
+			return err
 		}
 		if err := assignVariant(val.Value(), prop[1]); err != nil {
 			logger.Warningf("assignVariant(%s): %v", prop[0].(string), err)
@@ -221,22 +382,178 @@ func (p *Partition) Query() error {
 	return nil
 }
 
-// Resize attempts to resize a partition.
+// Resize attempts to resize a partition to size bytes. An error wrapping ErrInvalidParameter is
+// returned without attempting the resize if size is outside the range reported by GetSupportedSize.
+//
+// The partition is re-queried on success, so Size reflects the new size.
+//
+// Example:
+//		min, max, err := p.GetSupportedSize()
+//		p.Resize(max)
 //
 // Ref: https://docs.microsoft.com/en-us/previous-versions/windows/desktop/stormgmt/msft-partition-resize
 func (p *Partition) Resize(size uint64) (ExtendedStatus, error) {
+	defer p.svc.timeCall("Partition.Resize")()
 	stat := ExtendedStatus{}
+	min, max, err := p.GetSupportedSize()
+	if err != nil {
+		return stat, fmt.Errorf("Resize: %w", err)
+	}
+	if size < min {
+		return stat, fmt.Errorf("Resize(%d): size is below the minimum of %d bytes supported by the data on the partition: %w", size, min, ErrInvalidParameter)
+	}
+	if size > max {
+		return stat, fmt.Errorf("Resize(%d): size exceeds the maximum of %d bytes available to the partition: %w", size, max, ErrInvalidParameter)
+	}
+	if p.svc.skipDryRun("Partition.Resize(disk %d, partition %d, %d)", p.DiskNumber, p.PartitionNumber, size) {
+		return stat, nil
+	}
+	p.warnIfDynamic("Resize")
 	var extendedStatus ole.VARIANT
 	ole.VariantInit(&extendedStatus)
-	resultRaw, err := oleutil.CallMethod(p.handle, "Resize", size, &extendedStatus)
+	resultRaw, err := p.svc.wmi().CallMethod(p.handle, "Resize", size, &extendedStatus)
+	stat = p.svc.newExtendedStatus(&extendedStatus)
 	if err != nil {
 		return stat, fmt.Errorf("Resize: %w", err)
 	} else if val, ok := resultRaw.Value().(int32); val != 0 || !ok {
-		return stat, fmt.Errorf("error code returned during resize: %d", val)
+		return stat, &StorageError{"resize", val}
 	}
+	return stat, p.requery()
+}
+
+// GetSupportedSize returns the minimum and maximum sizes, in bytes, the partition can be resized to.
+//
+// Ref: https://docs.microsoft.com/en-us/previous-versions/windows/desktop/stormgmt/msft-partition-getsupportedsize
+func (p *Partition) GetSupportedSize() (uint64, uint64, error) {
+	var sizeMin, sizeMax, extendedStatus ole.VARIANT
+	ole.VariantInit(&sizeMin)
+	ole.VariantInit(&sizeMax)
+	ole.VariantInit(&extendedStatus)
+	defer ole.VariantClear(&sizeMin)
+	defer ole.VariantClear(&sizeMax)
+	defer ole.VariantClear(&extendedStatus)
+	resultRaw, err := p.svc.wmi().CallMethod(p.handle, "GetSupportedSize", &sizeMin, &sizeMax, &extendedStatus)
+	if err != nil {
+		return 0, 0, fmt.Errorf("GetSupportedSize: %w", err)
+	} else if val, ok := resultRaw.Value().(int32); val != 0 || !ok {
+		return 0, 0, &StorageError{"supported size query", val}
+	}
+	var min, max uint64
+	if err := assignVariant(sizeMin.Value(), &min); err != nil {
+		return 0, 0, fmt.Errorf("GetSupportedSize(SizeMin): %w", err)
+	}
+	if err := assignVariant(sizeMax.Value(), &max); err != nil {
+		return 0, 0, fmt.Errorf("GetSupportedSize(SizeMax): %w", err)
+	}
+	return min, max, nil
+}
+
+// SetReadOnly sets or clears the read-only attribute of the partition, and updates IsReadOnly on
+// success. Other partition attributes are left unchanged.
+//
+// Ref: https://docs.microsoft.com/en-us/previous-versions/windows/desktop/stormgmt/setattributes-msft-partition
+func (p *Partition) SetReadOnly(readOnly bool) (ExtendedStatus, error) {
+	stat := ExtendedStatus{}
+	var extendedStatus ole.VARIANT
+	ole.VariantInit(&extendedStatus)
+	resultRaw, err := p.svc.wmi().CallMethod(p.handle, "SetAttributes", readOnly, nil, nil, nil, nil, &extendedStatus)
+	stat = p.svc.newExtendedStatus(&extendedStatus)
+	if err != nil {
+		return stat, fmt.Errorf("SetAttributes: %w", err)
+	} else if val, ok := resultRaw.Value().(int32); val != 0 || !ok {
+		return stat, &StorageError{"SetAttributes", val}
+	}
+	p.IsReadOnly = readOnly
 	return stat, nil
 }
 
+// volumePollInterval is how often WaitForVolume checks for the partition's volume.
+var volumePollInterval = 500 * time.Millisecond
+
+// WaitForVolume waits until the volume on the partition is exposed by the storage provider, which
+// may lag behind partition creation or formatting, and returns it. It gives up once ctx is done.
+//
+// Close() must be called on the resulting Volume.
+//
+// Example:
+//		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+//		defer cancel()
+//		v, err := part.WaitForVolume(ctx)
+func (p *Partition) WaitForVolume(ctx context.Context) (Volume, error) {
+	ticker := time.NewTicker(volumePollInterval)
+	defer ticker.Stop()
+	for {
+		handles, err := p.svc.associators(p.handle, "MSFT_PartitionToVolume", "MSFT_Volume")
+		if err != nil {
+			return Volume{}, fmt.Errorf("WaitForVolume: %w", err)
+		}
+		if len(handles) > 0 {
+			releaseAll(handles[1:])
+			v := Volume{handle: handles[0], svc: p.svc}
+			return v, v.Query()
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return Volume{}, fmt.Errorf("WaitForVolume(disk %d, partition %d): %w", p.DiskNumber, p.PartitionNumber, ctx.Err())
+		}
+	}
+}
+
+// GetVolume returns the volume on the partition. It returns an error wrapping ErrVolumeNotFound if
+// the partition holds no volume, such as the Microsoft Reserved partition, or a volume which has
+// not yet been exposed by the storage provider; see WaitForVolume.
+//
+// Close() must be called on the resulting Volume.
+func (p *Partition) GetVolume() (Volume, error) {
+	handles, err := p.svc.associators(p.handle, "MSFT_PartitionToVolume", "MSFT_Volume")
+	if err != nil {
+		return Volume{}, fmt.Errorf("GetVolume: %w", err)
+	}
+	if len(handles) == 0 {
+		return Volume{}, fmt.Errorf("GetVolume(disk %d, partition %d): %w", p.DiskNumber, p.PartitionNumber, ErrVolumeNotFound)
+	}
+	releaseAll(handles[1:])
+	v := Volume{handle: handles[0], svc: p.svc}
+	return v, v.Query()
+}
+
+// GetPartitions returns the partitions on the disk, in order of partition number.
+//
+// Close() must be called on the resulting PartitionSet to ensure all partitions are released.
+//
+// Example: walk from a disk to its volumes
+//		parts, err := d.GetPartitions()
+//		if err != nil {
+//			return err
+//		}
+//		defer parts.Close()
+//		for _, p := range parts.Partitions {
+//			v, err := p.GetVolume()
+//			...
+//		}
+func (d *Disk) GetPartitions() (PartitionSet, error) {
+	parts := PartitionSet{}
+	handles, err := d.svc.associators(d.handle, "MSFT_DiskToPartition", "MSFT_Partition")
+	if err != nil {
+		return parts, fmt.Errorf("GetPartitions(%d): %w", d.Number, err)
+	}
+	for i, h := range handles {
+		part := Partition{handle: h, svc: d.svc}
+		if err := part.Query(); err != nil {
+			parts.Close()
+			releaseAll(handles[i:])
+			return PartitionSet{}, fmt.Errorf("GetPartitions(%d): %w", d.Number, err)
+		}
+		parts.Partitions = append(parts.Partitions, part)
+	}
+	sort.Slice(parts.Partitions, func(i, j int) bool {
+		return parts.Partitions[i].PartitionNumber < parts.Partitions[j].PartitionNumber
+	})
+	return parts, nil
+}
+
 // A PartitionSet contains one or more Partitions.
 type PartitionSet struct {
 	Partitions []Partition
@@ -251,27 +568,32 @@ func (s *PartitionSet) Close() {
 
 // GetPartitions queries for local partitions.
 //
-// Close() must be called on the resulting PartitionSet to ensure all disks are released.
+// Partitions are enumerated across all disks, without first retrieving a Disk.
+//
+// Close() must be called on the resulting PartitionSet to ensure all partitions are released.
 //
 // Get all partitions:
 //		svc.GetPartitions("")
 //
 // To get specific partitions, provide a valid WMI query filter string, for example:
 //		svc.GetPartitions("WHERE DiskNumber=1")
+//
+// Get all EFI system partitions:
+//		svc.GetPartitions(fmt.Sprintf("WHERE GptType='%s'", storage.GptTypes.SystemPartition))
 func (svc *Service) GetPartitions(filter string) (PartitionSet, error) {
+	defer svc.lock()()
 	parts := PartitionSet{}
 	query := "SELECT * FROM MSFT_Partition"
 	if filter != "" {
 		query = fmt.Sprintf("%s %s", query, filter)
 	}
-	raw, err := oleutil.CallMethod(svc.wmiSvc, "ExecQuery", query)
+	result, err := svc.execQuery(query)
 	if err != nil {
-		return parts, fmt.Errorf("ExecQuery(%s): %w", query, err)
+		return parts, err
 	}
-	result := raw.ToIDispatch()
 	defer result.Release()
 
-	countVar, err := oleutil.GetProperty(result, "Count")
+	countVar, err := svc.wmi().GetProperty(result, "Count")
 	if err != nil {
 		return parts, fmt.Errorf("oleutil.GetProperty(Count): %w", err)
 	}
@@ -279,11 +601,12 @@ func (svc *Service) GetPartitions(filter string) (PartitionSet, error) {
 
 	for i := 0; i < count; i++ {
 		part := Partition{}
-		itemRaw, err := oleutil.CallMethod(result, "ItemIndex", i)
+		item, err := svc.itemIndex(result, i, query)
 		if err != nil {
-			return parts, fmt.Errorf("oleutil.CallMethod(ItemIndex, %d): %w", i, err)
+			return parts, err
 		}
-		part.handle = itemRaw.ToIDispatch()
+		part.handle = item
+		part.svc = svc
 
 		if err := part.Query(); err != nil {
 			return parts, err
@@ -294,3 +617,28 @@ func (svc *Service) GetPartitions(filter string) (PartitionSet, error) {
 
 	return parts, nil
 }
+
+// GetPartitionByGuid returns the partition with the unique GPT partition GUID guid, with or without
+// braces. It returns ErrPartitionNotFound if no partition has that GUID.
+//
+// Close() must be called on the resulting Partition.
+//
+// Example:
+//		part, err := svc.GetPartitionByGuid("{5c6b1ad1-ba5d-4d4a-8e26-aa6e5b3f0d6e}")
+func (svc *Service) GetPartitionByGuid(guid string) (Partition, error) {
+	g, err := canonicalGUID(guid)
+	if err != nil {
+		return Partition{}, fmt.Errorf("GetPartitionByGuid: %w", err)
+	}
+	parts, err := svc.GetPartitions(fmt.Sprintf("WHERE Guid='%s'", g))
+	if err != nil {
+		return Partition{}, err
+	}
+	if len(parts.Partitions) == 0 {
+		return Partition{}, fmt.Errorf("GetPartitionByGuid(%s): %w", g, ErrPartitionNotFound)
+	}
+	for _, p := range parts.Partitions[1:] {
+		p.Close()
+	}
+	return parts.Partitions[0], nil
+}
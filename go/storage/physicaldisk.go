@@ -0,0 +1,239 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"fmt"
+
+	"github.com/google/logger"
+	"github.com/go-ole/go-ole"
+)
+
+// PhysicalDisk represents a MSFT_PhysicalDisk object.
+//
+// Ref: https://docs.microsoft.com/en-us/previous-versions/windows/desktop/stormgmt/msft-physicaldisk
+type PhysicalDisk struct {
+	DeviceID          string
+	FriendlyName      string
+	SerialNumber      string
+	MediaType         int32
+	BusType           int32
+	Size              uint64
+	HealthStatus      int32
+	OperationalStatus []int32
+	SpindleSpeed      int32
+
+	handle *ole.IDispatch
+	svc    *Service
+}
+
+// mediaTypeNames maps MSFT_PhysicalDisk MediaType values to their names.
+var mediaTypeNames = map[int32]string{
+	0: "Unspecified",
+	3: "HDD",
+	4: "SSD",
+	5: "SCM",
+}
+
+// MediaTypeString returns the name of an MSFT_PhysicalDisk MediaType value, such as "HDD" or "SSD".
+func MediaTypeString(mt int32) string {
+	return enumName(mediaTypeNames, mt)
+}
+
+// MediaTypeName returns the name of the physical disk's media type.
+func (p *PhysicalDisk) MediaTypeName() string {
+	return MediaTypeString(p.MediaType)
+}
+
+// Bus returns the type of bus the physical disk is attached through.
+func (p *PhysicalDisk) Bus() BusType {
+	return BusType(p.BusType)
+}
+
+// Health returns the health status of the physical disk.
+//
+// Example:
+//		if pd.Health() != storage.HealthHealthy {
+//			return fmt.Errorf("refusing to image onto %s: disk is %s", pd.FriendlyName, pd.Health())
+//		}
+func (p *PhysicalDisk) Health() HealthStatus {
+	return HealthStatus(p.HealthStatus)
+}
+
+// Close releases the handle to the physical disk.
+func (p *PhysicalDisk) Close() {
+	if p.handle != nil {
+		p.handle.Release()
+	}
+}
+
+// Query reads and populates the physical disk state.
+func (p *PhysicalDisk) Query() error {
+	if p.handle == nil {
+		return fmt.Errorf("invalid handle")
+	}
+
+	for _, pr := range [][]interface{}{
+		[]interface{}{"DeviceId", &p.DeviceID},
+		[]interface{}{"FriendlyName", &p.FriendlyName},
+		[]interface{}{"SerialNumber", &p.SerialNumber},
+		[]interface{}{"MediaType", &p.MediaType},
+		[]interface{}{"BusType", &p.BusType},
+		[]interface{}{"Size", &p.Size},
+		[]interface{}{"HealthStatus", &p.HealthStatus},
+		[]interface{}{"SpindleSpeed", &p.SpindleSpeed},
+	} {
+		prop, err := p.svc.getProperty(p.handle, pr[0].(string))
+		if err != nil {
+			return err
+		}
+		if err := assignVariant(prop.Value(), pr[1]); err != nil {
+			logger.Warningf("assignVariant(%s): %v", pr[0].(string), err)
+		}
+	}
+
+	prop, err := p.svc.getProperty(p.handle, "OperationalStatus")
+	if err != nil {
+		return err
+	}
+	p.OperationalStatus = int32Array(prop)
+	return nil
+}
+
+// ReliabilityCounter represents a MSFT_StorageReliabilityCounter object, the SMART-like
+// wear and error statistics reported by a physical disk.
+//
+// Counters the device does not report are left at zero.
+//
+// Ref: https://docs.microsoft.com/en-us/previous-versions/windows/desktop/stormgmt/msft-storagereliabilitycounter
+type ReliabilityCounter struct {
+	DeviceID               string
+	Temperature            uint8
+	TemperatureMax         uint8
+	Wear                   uint8
+	ReadErrorsTotal        uint64
+	ReadErrorsCorrected    uint64
+	ReadErrorsUncorrected  uint64
+	WriteErrorsTotal       uint64
+	WriteErrorsCorrected   uint64
+	WriteErrorsUncorrected uint64
+	ReadLatencyMax         uint64
+	WriteLatencyMax        uint64
+	FlushLatencyMax        uint64
+	PowerOnHours           int32
+	StartStopCycleCount    int32
+	LoadUnloadCycleCount   int32
+}
+
+// GetReliabilityCounter returns the reliability counters of the physical disk.
+//
+// Example:
+//		rc, err := pd.GetReliabilityCounter()
+//		if rc.Wear > 90 {
+//			// schedule a replacement
+//		}
+func (p *PhysicalDisk) GetReliabilityCounter() (ReliabilityCounter, error) {
+	rc := ReliabilityCounter{}
+	h, err := p.svc.associator(p.handle, "MSFT_PhysicalDiskToStorageReliabilityCounter", "MSFT_StorageReliabilityCounter")
+	if err != nil {
+		return rc, fmt.Errorf("GetReliabilityCounter(%s): %w", p.FriendlyName, err)
+	}
+	defer h.Release()
+
+	for _, pr := range [][]interface{}{
+		[]interface{}{"DeviceId", &rc.DeviceID},
+		[]interface{}{"Temperature", &rc.Temperature},
+		[]interface{}{"TemperatureMax", &rc.TemperatureMax},
+		[]interface{}{"Wear", &rc.Wear},
+		[]interface{}{"ReadErrorsTotal", &rc.ReadErrorsTotal},
+		[]interface{}{"ReadErrorsCorrected", &rc.ReadErrorsCorrected},
+		[]interface{}{"ReadErrorsUncorrected", &rc.ReadErrorsUncorrected},
+		[]interface{}{"WriteErrorsTotal", &rc.WriteErrorsTotal},
+		[]interface{}{"WriteErrorsCorrected", &rc.WriteErrorsCorrected},
+		[]interface{}{"WriteErrorsUncorrected", &rc.WriteErrorsUncorrected},
+		[]interface{}{"ReadLatencyMax", &rc.ReadLatencyMax},
+		[]interface{}{"WriteLatencyMax", &rc.WriteLatencyMax},
+		[]interface{}{"FlushLatencyMax", &rc.FlushLatencyMax},
+		[]interface{}{"PowerOnHours", &rc.PowerOnHours},
+		[]interface{}{"StartStopCycleCount", &rc.StartStopCycleCount},
+		[]interface{}{"LoadUnloadCycleCount", &rc.LoadUnloadCycleCount},
+	} {
+		prop, err := p.svc.getProperty(h, pr[0].(string))
+		if err != nil {
+			return rc, err
+		}
+		if err := assignVariant(prop.Value(), pr[1]); err != nil {
+			logger.Warningf("assignVariant(%s): %v", pr[0].(string), err)
+		}
+	}
+	return rc, nil
+}
+
+// A PhysicalDiskSet contains one or more PhysicalDisks.
+type PhysicalDiskSet struct {
+	PhysicalDisks []PhysicalDisk
+}
+
+// Close releases all PhysicalDisk handles inside a PhysicalDiskSet.
+func (s *PhysicalDiskSet) Close() {
+	for _, p := range s.PhysicalDisks {
+		p.Close()
+	}
+}
+
+// GetPhysicalDisks queries for local physical disks.
+//
+// Close() must be called on the resulting PhysicalDiskSet to ensure all physical disks are released.
+//
+// Get all physical disks:
+//		svc.GetPhysicalDisks("")
+//
+// To get specific physical disks, provide a valid WMI query filter string, for example:
+//		svc.GetPhysicalDisks("WHERE DeviceId='0'")
+func (svc *Service) GetPhysicalDisks(filter string) (PhysicalDiskSet, error) {
+	defer svc.lock()()
+	pset := PhysicalDiskSet{}
+	query := "SELECT * FROM MSFT_PhysicalDisk"
+	if filter != "" {
+		query = fmt.Sprintf("%s %s", query, filter)
+	}
+	result, err := svc.execQuery(query)
+	if err != nil {
+		return pset, err
+	}
+	defer result.Release()
+
+	countVar, err := svc.wmi().GetProperty(result, "Count")
+	if err != nil {
+		return pset, fmt.Errorf("oleutil.GetProperty(Count): %w", err)
+	}
+	count := int(countVar.Val)
+
+	for i := 0; i < count; i++ {
+		p := PhysicalDisk{}
+		item, err := svc.itemIndex(result, i, query)
+		if err != nil {
+			return pset, err
+		}
+		p.handle = item
+		p.svc = svc
+		if err := p.Query(); err != nil {
+			return pset, err
+		}
+		pset.PhysicalDisks = append(pset.PhysicalDisks, p)
+	}
+
+	return pset, nil
+}
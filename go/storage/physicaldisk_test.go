@@ -0,0 +1,40 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"testing"
+)
+
+func TestMediaTypeString(t *testing.T) {
+	tests := []struct {
+		in   int32
+		want string
+	}{
+		{3, "HDD"},
+		{4, "SSD"},
+		{5, "SCM"},
+		{1, "Unknown(1)"},
+	}
+	for _, tt := range tests {
+		if got := MediaTypeString(tt.in); got != tt.want {
+			t.Errorf("MediaTypeString(%d) = %q, want %q", tt.in, got, tt.want)
+		}
+		p := PhysicalDisk{MediaType: tt.in}
+		if got := p.MediaTypeName(); got != tt.want {
+			t.Errorf("MediaTypeName() with MediaType %d = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/logger"
+	"github.com/go-ole/go-ole"
+)
+
+// transientCodes holds the COM, RPC and WMI error codes which commonly clear on their own, such as
+// those returned while the WMI service or storage provider is still starting after boot.
+var transientCodes = map[uint32]bool{
+	0x80010001: true, // RPC_E_CALL_REJECTED
+	0x80010108: true, // RPC_E_DISCONNECTED
+	0x8001010A: true, // RPC_E_SERVERCALL_RETRYLATER
+	0x800706BA: true, // RPC_S_SERVER_UNAVAILABLE
+	0x800706BE: true, // RPC_S_CALL_FAILED
+	0x80041013: true, // WBEM_E_PROVIDER_LOAD_FAILURE
+	0x80041014: true, // WBEM_E_INITIALIZATION_FAILURE
+	0x80041015: true, // WBEM_E_TRANSPORT_FAILURE
+	0x80041033: true, // WBEM_E_SHUTTING_DOWN
+}
+
+// IsTransient reports whether err is a WMI failure which is likely to succeed if retried, such as a
+// provider load failure or an RPC disconnect, or a query which timed out. Logical errors, such as an
+// invalid query, are not transient.
+func IsTransient(err error) bool {
+	if errors.Is(err, ErrQueryTimeout) {
+		return true
+	}
+	var oleErr *ole.OleError
+	if !errors.As(err, &oleErr) {
+		return false
+	}
+	if transientCodes[uint32(oleErr.Code())] {
+		return true
+	}
+	// Errors raised by the provider are reported through the exception info.
+	if info, ok := oleErr.SubError().(ole.EXCEPINFO); ok {
+		return transientCodes[info.SCODE()]
+	}
+	return false
+}
+
+// GetVolumesWithRetry queries for volumes like GetVolumes, retrying up to attempts times in total
+// while the query fails with an error accepted by retryable, or by IsTransient if retryable is nil.
+// The delay between attempts starts at delay and doubles after each retry. Other errors are returned
+// immediately.
+//
+// Close() must be called on the resulting VolumeSet to ensure all volumes are released.
+//
+// Example:
+//		vset, err := svc.GetVolumesWithRetry("WHERE DriveLetter='C'", 5, time.Second, nil)
+func (svc *Service) GetVolumesWithRetry(filter string, attempts int, delay time.Duration, retryable func(error) bool) (VolumeSet, error) {
+	if retryable == nil {
+		retryable = IsTransient
+	}
+	for i := 1; ; i++ {
+		vset, err := svc.GetVolumes(filter)
+		if err == nil || i >= attempts || !retryable(err) {
+			return vset, err
+		}
+		vset.Close()
+		logger.Warningf("GetVolumes(%q) failed (attempt %d of %d), retrying in %v: %v", filter, i, attempts, delay, err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/go-ole/go-ole"
+)
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{ole.NewError(0x80041013), true},
+		{fmt.Errorf("ExecQuery: %w", ole.NewError(0x800706BA)), true},
+		{fmt.Errorf("after 1s: %w", ErrQueryTimeout), true},
+		{exceptionError(0x80041013), true},
+		{ole.NewError(0x80041017), false},
+		{ole.NewError(ole.E_ACCESSDENIED), false},
+		{errors.New("invalid handle"), false},
+	}
+	for _, tt := range tests {
+		if got := IsTransient(tt.err); got != tt.want {
+			t.Errorf("IsTransient(%v) = %t, want %t", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestGetVolumesWithRetry(t *testing.T) {
+	errFake := errors.New("fake failure")
+	tests := []struct {
+		desc      string
+		retryable func(error) bool
+		want      int
+	}{
+		{"default", nil, 1},
+		{"retryable", func(err error) bool { return errors.Is(err, errFake) }, 3},
+		{"not retryable", func(error) bool { return false }, 1},
+	}
+	for _, tt := range tests {
+		var queries int
+		svc := &Service{caller: &fakeWMI{query: func(string) (*ole.IDispatch, error) {
+			queries++
+			return nil, errFake
+		}}}
+		if _, err := svc.GetVolumesWithRetry("", 3, 0, tt.retryable); !errors.Is(err, errFake) {
+			t.Errorf("%s: GetVolumesWithRetry() returned %v, want %v", tt.desc, err, errFake)
+		}
+		if queries != tt.want {
+			t.Errorf("%s: GetVolumesWithRetry() queried %d times, want %d", tt.desc, queries, tt.want)
+		}
+	}
+}
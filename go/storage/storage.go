@@ -12,46 +12,413 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-// +build windows
-
 // Package storage provides storage management functionality.
 package storage
 
 import (
 	"errors"
 	"fmt"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/scjalliance/comshim"
+	"github.com/google/logger"
 	"github.com/go-ole/go-ole"
 	"github.com/go-ole/go-ole/oleutil"
-	"github.com/google/winops/powershell"
 )
 
 var (
 	// ErrUnmarshal indicates an error attempting to unmarshal a response from a PowerShell cmdlet.
 	ErrUnmarshal = errors.New("unable to unmarshal powershell output")
+	// ErrSystemDisk indicates a destructive operation was refused because it targets the disk hosting the running OS.
+	ErrSystemDisk = errors.New("refusing to modify the disk hosting the running OS")
+	// ErrSystemPartition indicates a partition was not deleted or taken offline because it is the system or
+	// boot partition.
+	ErrSystemPartition = errors.New("refusing to remove the system or boot partition")
+	// ErrFormatCancelled indicates a background format was cancelled before it completed.
+	ErrFormatCancelled = errors.New("format cancelled")
+	// ErrQueryTimeout indicates a WMI query did not complete within the timeout set by SetQueryTimeout.
+	ErrQueryTimeout = errors.New("WMI query timed out")
+	// ErrNotSupported indicates the device does not support the requested setting.
+	ErrNotSupported = errors.New("not supported by the device")
+	// ErrDiskAlreadyInitialized indicates a disk could not be initialized because it already has a partition style.
+	ErrDiskAlreadyInitialized = errors.New("disk is already initialized")
+	// ErrDiskPolicyOffline indicates a disk could not be brought online because the SAN policy keeps it offline.
+	// Retrying does not help; the SAN policy or the disk's offline state must be changed by an administrator.
+	ErrDiskPolicyOffline = errors.New("disk is kept offline by policy")
+	// ErrPartialQuery indicates some properties of an object could not be converted, and were left at
+	// their zero values, while the others were read.
+	ErrPartialQuery = errors.New("some properties could not be read")
+	// ErrDedupNotInstalled indicates the Data Deduplication feature is not installed.
+	ErrDedupNotInstalled = errors.New("data deduplication is not installed")
+	// ErrNotElevated indicates the process is not running with administrator privileges.
+	ErrNotElevated = errors.New("administrator privileges are required")
 
-	fnPSCmd = powershell.Command
+	dismExe    = os.ExpandEnv(`${windir}\System32\dism.exe`)
+	fsutilExe  = os.ExpandEnv(`${windir}\System32\fsutil.exe`)
+	mbr2gptExe = os.ExpandEnv(`${windir}\System32\mbr2gpt.exe`)
 )
 
-// ExtendedStatus is a placeholder for MSFT_StorageExtendedStatus
+// ExtendedStatus represents a MSFT_StorageExtendedStatus object, the detailed status returned by
+// storage management methods. It is empty if the method did not return extended status.
 //
 // Ref: https://docs.microsoft.com/en-us/previous-versions/windows/desktop/stormgmt/msft-storageextendedstatus
-type ExtendedStatus struct{}
+type ExtendedStatus struct {
+	CIMStatusCode            int32
+	CIMStatusCodeDescription string
+	ErrorType                int32
+	ErrorSource              string
+	Message                  string
+	MessageID                string
+	PerceivedSeverity        int32
+	ProbableCause            int32
+	ProbableCauseDescription string
+	RecommendedActions       []string
+}
+
+// newExtendedStatus reads the MSFT_StorageExtendedStatus instance returned in the ExtendedStatus
+// output parameter of a method, and clears the parameter.
+func (svc *Service) newExtendedStatus(v *ole.VARIANT) ExtendedStatus {
+	stat := ExtendedStatus{}
+	h := v.ToIDispatch()
+	if h == nil {
+		return stat
+	}
+	defer v.Clear()
+
+	for _, p := range [][]interface{}{
+		[]interface{}{"CIMStatusCode", &stat.CIMStatusCode},
+		[]interface{}{"CIMStatusCodeDescription", &stat.CIMStatusCodeDescription},
+		[]interface{}{"ErrorType", &stat.ErrorType},
+		[]interface{}{"ErrorSource", &stat.ErrorSource},
+		[]interface{}{"Message", &stat.Message},
+		[]interface{}{"MessageID", &stat.MessageID},
+		[]interface{}{"PerceivedSeverity", &stat.PerceivedSeverity},
+		[]interface{}{"ProbableCause", &stat.ProbableCause},
+		[]interface{}{"ProbableCauseDescription", &stat.ProbableCauseDescription},
+	} {
+		prop, err := svc.getProperty(h, p[0].(string))
+		if err != nil {
+			logger.Warningf("ExtendedStatus: %v", err)
+			continue
+		}
+		if err := assignVariant(prop.Value(), p[1]); err != nil {
+			logger.Warningf("assignVariant(%s): %v", p[0].(string), err)
+		}
+	}
+	prop, err := svc.getProperty(h, "RecommendedActions")
+	if err != nil {
+		logger.Warningf("ExtendedStatus: %v", err)
+	} else if arr := prop.ToArray(); arr != nil {
+		stat.RecommendedActions = arr.ToStringArray()
+	}
+	return stat
+}
 
 // Service represents a connection to the host Storage service (in WMI).
+//
+// A Service may be shared between goroutines; calls made through it are serialized.
 type Service struct {
 	wmiIntf *ole.IDispatch
 	wmiSvc  *ole.IDispatch
+	// host is the remote host connected to, and empty for the local machine.
+	host string
+	// caller makes the OLE calls to WMI.
+	caller wmiCaller
+	// mu serializes calls on wmiSvc. It is a pointer so that Service can be returned by value.
+	mu        *sync.Mutex
+	reconnect func() (Service, error)
+	// pending tracks the goroutines started by background, which Close waits for. done is closed by
+	// Close to stop those which run until cancelled, such as WatchVolumes.
+	pending  *sync.WaitGroup
+	done     chan struct{}
+	doneOnce *sync.Once
+
+	cacheTTL     time.Duration
+	volumeCache  map[string]volumeCacheEntry
+	queryTimeout time.Duration
+
+	// metrics holds the MetricsSink, and protectSystemDisk and dryRun the bool settings. They are
+	// read without holding mu, as calls on objects do not take the Service lock.
+	metrics           *atomic.Value
+	protectSystemDisk *atomic.Value
+	dryRun            *atomic.Value
+}
+
+// ProtectSystemDisk toggles system disk protection. While enabled, destructive methods (Disk.Clear,
+// Disk.Initialize, Disk.ConvertToGPT, Disk.ConvertToMBR, Disk.SetGUID, Disk.SetSignature and
+// Partition.Delete) refuse to operate on the disk hosting the running OS, unless AllowSystemDisk is
+// set on the Disk or Partition being modified.
+//
+// Protection applies to objects retrieved through this Service.
+func (svc *Service) ProtectSystemDisk(enable bool) {
+	if svc.protectSystemDisk != nil {
+		svc.protectSystemDisk.Store(enable)
+	}
+}
+
+// DryRun toggles dry run mode. While enabled, destructive methods log the call they would make and
+// return success without making any change. Queries and other methods run as normal. The methods
+// covered are:
+//
+//		Volume: Format, Repair, OfflineRepair, Resize, Dismount, Eject and SetDedupMode
+//		Disk: Clear, Initialize, ConvertToGPT, ConvertToMBR, CreatePartition, Offline and SetAttributes
+//		Partition: Delete, Resize and RemoveAccessPath
+//		StoragePool: CreateVirtualDisk
+//		Service: RemoveMountPoint
+//
+// Methods creating an object, such as Disk.CreatePartition, return an empty object in dry run mode.
+//
+// Dry run mode applies to objects retrieved through this Service.
+//
+// Example: rehearse an imaging recipe
+//		svc.DryRun(true)
+func (svc *Service) DryRun(enable bool) {
+	if svc.dryRun != nil {
+		svc.dryRun.Store(enable)
+	}
+}
+
+// enabled reports whether the bool setting held by v is set. It is safe to use on a nil v.
+func enabled(v *atomic.Value) bool {
+	if v == nil {
+		return false
+	}
+	b, _ := v.Load().(bool)
+	return b
+}
+
+// skipDryRun reports whether dry run mode is enabled, logging the call that would have been made if so.
+func (svc *Service) skipDryRun(format string, args ...interface{}) bool {
+	if svc == nil || !enabled(svc.dryRun) {
+		return false
+	}
+	logger.Infof("dry run: skipping "+format, args...)
+	return true
 }
 
-// Connect connects to the WMI provider for managing storage objects.
+// checkLocal returns an error wrapping ErrNotSupported if the Service is connected to a remote host.
+// Methods making Win32 calls or running tools act on the local machine, so cannot honor a remote
+// connection.
+func (svc *Service) checkLocal() error {
+	if svc != nil && svc.host != "" {
+		return fmt.Errorf("not supported for remote host %s: %w", svc.host, ErrNotSupported)
+	}
+	return nil
+}
+
+// storageNamespace is the WMI namespace hosting the Storage Management API.
+const storageNamespace = `ROOT\Microsoft\Windows\Storage`
+
+// WMI namespaces which may be passed to NewService.
+const (
+	// NamespaceStorage hosts the Storage Management API (MSFT_Disk, MSFT_Volume, ...).
+	NamespaceStorage = storageNamespace
+	// NamespaceVolumeEncryption hosts the BitLocker Drive Encryption provider (Win32_EncryptableVolume).
+	NamespaceVolumeEncryption = encryptionNamespace
+	// NamespaceDeduplication hosts the Data Deduplication provider (MSFT_DedupVolume).
+	NamespaceDeduplication = dedupNamespace
+	// NamespaceCIMV2 hosts the core Win32 classes (Win32_LogicalDisk, Win32_DiskDrive, ...).
+	NamespaceCIMV2 = `ROOT\CIMV2`
+)
+
+// Credentials holds the account used to authenticate a remote connection.
+//
+// Password is copied for the connection attempt and is neither retained nor modified, so the same
+// Credentials may be used again, such as by a reconnect function. Callers wishing to limit how long the
+// password remains in memory can overwrite it once they no longer need to connect.
+type Credentials struct {
+	User     string
+	Password []byte
+	Domain   string
+}
+
+// DCOM authentication levels.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/wmisdk/swbemsecurity-authenticationlevel
+const (
+	AuthnLevelDefault      uint32 = 0
+	AuthnLevelNone         uint32 = 1
+	AuthnLevelConnect      uint32 = 2
+	AuthnLevelCall         uint32 = 3
+	AuthnLevelPkt          uint32 = 4
+	AuthnLevelPktIntegrity uint32 = 5
+	AuthnLevelPktPrivacy   uint32 = 6
+)
+
+// DCOM impersonation levels.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/wmisdk/swbemsecurity-impersonationlevel
+const (
+	ImpLevelAnonymous   uint32 = 1
+	ImpLevelIdentify    uint32 = 2
+	ImpLevelImpersonate uint32 = 3
+	ImpLevelDelegate    uint32 = 4
+)
+
+// sFalse (S_FALSE) is returned by CoInitializeEx when COM is already initialized on the thread.
+const sFalse = 0x00000001
+
+// Initialize initializes COM in the multithreaded apartment for the calling goroutine, which is
+// locked to its OS thread until Uninitialize is called. Every successful call to Initialize must be
+// balanced by a call to Uninitialize from the same goroutine.
+//
+// Connect initializes COM itself, so Initialize is not required to use a Service from any goroutine.
+// It is provided for callers making their own COM calls alongside the package.
+//
+// Example:
+//		if err := storage.Initialize(); err != nil {
+//			return err
+//		}
+//		defer storage.Uninitialize()
+func Initialize() error {
+	runtime.LockOSThread()
+	if err := ole.CoInitializeEx(0, ole.COINIT_MULTITHREADED); err != nil {
+		var oleErr *ole.OleError
+		if errors.As(err, &oleErr) && oleErr.Code() == sFalse {
+			return nil
+		}
+		runtime.UnlockOSThread()
+		return fmt.Errorf("CoInitializeEx: %w", err)
+	}
+	return nil
+}
+
+// Uninitialize releases COM for the calling goroutine, and unlocks it from its OS thread. It must
+// only be called after a successful call to Initialize.
+func Uninitialize() {
+	ole.CoUninitialize()
+	runtime.UnlockOSThread()
+}
+
+// Connect connects to the WMI provider for managing storage objects on the local machine. Use
+// ConnectWithOptions to connect to a remote machine.
 // You must call Close() to release the provider when finished.
 //
+// COM is initialized by Connect and remains initialized until the Service is closed, so the Service
+// may be used from any goroutine without calling Initialize.
+//
 // Example: storage.Connect()
 func Connect() (Service, error) {
+	return connect("", storageNamespace, nil, AuthnLevelDefault, 0)
+}
+
+// ConnectOptions selects the machine, account and DCOM security used by ConnectWithOptions. The zero
+// value connects to the Storage Management API on the local machine as the current user.
+//
+// An empty Host connects to the local machine, and an empty Namespace to NamespaceStorage. Nil
+// Credentials connect as the current user; WMI does not accept credentials for local connections.
+// Zero AuthLevel and ImpLevel values leave the DCOM defaults in place.
+type ConnectOptions struct {
+	Host        string
+	Credentials *Credentials
+	Namespace   string
+	AuthLevel   uint32
+	ImpLevel    uint32
+}
+
+// ConnectWithOptions connects to a WMI provider, locally or on a remote host over DCOM, as selected by
+// opts. Methods of the resulting Service, and of the objects retrieved through it, then operate against
+// that machine. Methods working through Win32 calls, local tools or the local file system rather than
+// WMI, such as Volume.Dismount, Volume.MountAt or Disk.SetWriteCacheEnabled, return ErrNotSupported
+// for a remote host.
+// You must call Close() to release the provider when finished.
+//
+// Example:
+//		svc, err := storage.ConnectWithOptions(storage.ConnectOptions{
+//			Host:        "host1",
+//			Credentials: &storage.Credentials{User: "admin", Password: password, Domain: "CORP"},
+//			AuthLevel:   storage.AuthnLevelPktPrivacy,
+//		})
+func ConnectWithOptions(opts ConnectOptions) (Service, error) {
+	if opts.Host == "" && opts.Credentials != nil {
+		return Service{}, fmt.Errorf("credentials can only be specified for a remote connection")
+	}
+	namespace := opts.Namespace
+	if namespace == "" {
+		namespace = storageNamespace
+	}
+	return connect(opts.Host, namespace, opts.Credentials, opts.AuthLevel, opts.ImpLevel)
+}
+
+// NewService connects to the WMI provider for namespace on the local machine, or to the Storage
+// Management API if namespace is empty. Methods of the resulting Service only work against classes
+// hosted in that namespace; for example, GetVolumes requires NamespaceStorage.
+//
+// COM is initialized by NewService and remains initialized until the Service is closed, so callers
+// must defer Close() to avoid leaking the COM connection.
+//
+// Example:
+//		svc, err := storage.NewService(storage.NamespaceVolumeEncryption)
+//		if err != nil {
+//			return err
+//		}
+//		defer svc.Close()
+func NewService(namespace string) (Service, error) {
+	if namespace == "" {
+		namespace = storageNamespace
+	}
+	return connect("", namespace, nil, AuthnLevelDefault, 0)
+}
+
+// ConnectElevated is like Connect, but first verifies the process is running elevated, and returns
+// ErrNotElevated if it is not. Most storage operations fail with an access denied error deep in the
+// WMI provider when run without administrator privileges; this surfaces the problem up front.
+//
+// Example:
+//		svc, err := storage.ConnectElevated()
+//		if errors.Is(err, storage.ErrNotElevated) {
+//			// ask the user to run as administrator
+//		}
+func ConnectElevated() (Service, error) {
+	elevated, err := isElevated()
+	if err != nil {
+		return Service{}, err
+	}
+	if !elevated {
+		return Service{}, ErrNotElevated
+	}
+	return Connect()
+}
+
+// IsElevated reports whether the calling process is running with an elevated (administrator) token.
+// The check is always made against the local process, including for remote connections.
+func (svc *Service) IsElevated() (bool, error) {
+	return isElevated()
+}
+
+// ConnectRemoteWithAuth connects to the WMI provider for managing storage objects on a remote host,
+// using explicit DCOM authentication and impersonation levels.
+// You must call Close() to release the provider when finished.
+//
+// Example:
+//		storage.ConnectRemoteWithAuth("host1", storage.Credentials{User: "admin", Password: []byte("pass"), Domain: "CORP"},
+//			storage.AuthnLevelPktPrivacy, storage.ImpLevelImpersonate)
+func ConnectRemoteWithAuth(host string, creds Credentials, authLevel, impLevel uint32) (Service, error) {
+	if host == "" {
+		return Service{}, fmt.Errorf("host must be specified for a remote connection")
+	}
+	return connect(host, storageNamespace, &creds, authLevel, impLevel)
+}
+
+// connect establishes the WMI connection to namespace. An empty host connects to the local machine,
+// in which case creds must be nil. Zero authLevel and impLevel values leave the DCOM defaults in place.
+func connect(host, namespace string, creds *Credentials, authLevel, impLevel uint32) (Service, error) {
 	comshim.Add(1)
-	svc := Service{}
+	svc := Service{
+		host:              host,
+		caller:            oleCaller{},
+		mu:                &sync.Mutex{},
+		pending:           &sync.WaitGroup{},
+		done:              make(chan struct{}),
+		doneOnce:          &sync.Once{},
+		metrics:           &atomic.Value{},
+		protectSystemDisk: &atomic.Value{},
+		dryRun:            &atomic.Value{},
+	}
 
 	unknown, err := oleutil.CreateObject("WbemScripting.SWbemLocator")
 	if err != nil {
@@ -64,7 +431,28 @@ func Connect() (Service, error) {
 		comshim.Done()
 		return svc, fmt.Errorf("QueryInterface: %w", err)
 	}
-	serviceRaw, err := oleutil.CallMethod(svc.wmiIntf, "ConnectServer", nil, `\\.\ROOT\Microsoft\Windows\Storage`)
+
+	if authLevel != AuthnLevelDefault || impLevel != 0 {
+		if err := svc.setSecurity(svc.wmiIntf, authLevel, impLevel); err != nil {
+			svc.Close()
+			return svc, err
+		}
+	}
+
+	var serviceRaw *ole.VARIANT
+	if host == "" {
+		serviceRaw, err = svc.wmi().CallMethod(svc.wmiIntf, "ConnectServer", nil, `\\.\`+namespace)
+	} else {
+		var user, password interface{}
+		if creds != nil && creds.User != "" {
+			user = creds.User
+			if creds.Domain != "" {
+				user = creds.Domain + `\` + creds.User
+			}
+			password = string(creds.Password)
+		}
+		serviceRaw, err = svc.wmi().CallMethod(svc.wmiIntf, "ConnectServer", host, namespace, user, password)
+	}
 	if err != nil {
 		svc.Close()
 		return svc, fmt.Errorf("ConnectServer: %w", err)
@@ -74,9 +462,478 @@ func Connect() (Service, error) {
 	return svc, nil
 }
 
+// SetReconnect configures fn to be called to establish a new connection when a query fails because
+// the WMI service is unavailable, such as after WinMgmt restarts. The failed query is retried once
+// on the new connection. Objects retrieved before the reconnect remain bound to the old connection.
+//
+// Example:
+//		svc.SetReconnect(storage.Connect)
+func (svc *Service) SetReconnect(fn func() (Service, error)) {
+	defer svc.lock()()
+	svc.reconnect = fn
+}
+
+// rpcSServerUnavailable (RPC_S_SERVER_UNAVAILABLE) is returned when the WMI service hosting the
+// connection has gone away.
+const rpcSServerUnavailable = 0x800706BA
+
+// isServerUnavailable reports whether err indicates the WMI service is no longer reachable.
+func isServerUnavailable(err error) bool {
+	var oleErr *ole.OleError
+	if !errors.As(err, &oleErr) {
+		return false
+	}
+	if oleErr.Code() == rpcSServerUnavailable {
+		return true
+	}
+	// Errors raised by the provider are reported through the exception info.
+	if info, ok := oleErr.SubError().(ole.EXCEPINFO); ok {
+		return info.SCODE() == rpcSServerUnavailable
+	}
+	return false
+}
+
+// execQuery runs a WQL query, and returns the resulting SWbemObjectSet, which must be released by
+// the caller. If the WMI service is unavailable and a reconnect function is configured, the
+// connection is replaced and the query retried once. The caller must hold the Service lock.
+func (svc *Service) execQuery(query string) (*ole.IDispatch, error) {
+	defer svc.timeCall("ExecQuery")()
+	result, err := svc.runQuery(query)
+	if err != nil && svc.reconnect != nil && isServerUnavailable(err) {
+		logger.Warningf("WMI service unavailable, reconnecting: %v", err)
+		newSvc, rerr := svc.reconnect()
+		if rerr != nil {
+			return nil, fmt.Errorf("ExecQuery(%s): %v; reconnect: %w", query, err, rerr)
+		}
+		if svc.wmiIntf != nil {
+			svc.wmiIntf.Release()
+		}
+		if svc.wmiSvc != nil {
+			svc.wmiSvc.Release()
+		}
+		svc.wmiIntf, svc.wmiSvc = newSvc.wmiIntf, newSvc.wmiSvc
+		// The new connection holds its own COM reference; drop the one held by the old connection.
+		comshim.Done()
+		result, err = svc.runQuery(query)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ExecQuery(%s): %w", query, err)
+	}
+	return result, nil
+}
+
+// runQuery runs a WQL query and waits for its results to be retrieved, giving up with
+// ErrQueryTimeout if a query timeout is set and expires first.
+//
+// A timed query runs in the background, holding its own reference to the connection, so that a
+// provider blocked in ExecQuery or while returning results cannot hold up the caller. An abandoned
+// query releases its results when it finishes, and Close waits for it.
+func (svc *Service) runQuery(query string) (*ole.IDispatch, error) {
+	if svc.queryTimeout <= 0 {
+		return svc.fetchQuery(svc.wmiSvc, query)
+	}
+	type queryResult struct {
+		result *ole.IDispatch
+		err    error
+	}
+	c := make(chan queryResult)
+	abandoned := make(chan struct{})
+	wmiSvc := svc.wmiSvc
+	if wmiSvc != nil {
+		wmiSvc.AddRef()
+	}
+	svc.background(func() {
+		if wmiSvc != nil {
+			defer wmiSvc.Release()
+		}
+		result, err := svc.fetchQuery(wmiSvc, query)
+		select {
+		case c <- queryResult{result, err}:
+		case <-abandoned:
+			if err == nil {
+				result.Release()
+			}
+		}
+	})
+	timer := time.NewTimer(svc.queryTimeout)
+	defer timer.Stop()
+	select {
+	case r := <-c:
+		return r.result, r.err
+	case <-timer.C:
+		close(abandoned)
+		return nil, fmt.Errorf("after %v: %w", svc.queryTimeout, ErrQueryTimeout)
+	}
+}
+
+// fetchQuery runs a WQL query, and reads the result count so that the results have been retrieved
+// from the provider when it returns.
+func (svc *Service) fetchQuery(wmiSvc *ole.IDispatch, query string) (*ole.IDispatch, error) {
+	result, err := svc.wmi().ExecQuery(wmiSvc, query)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := svc.wmi().GetProperty(result, "Count"); err != nil {
+		result.Release()
+		return nil, err
+	}
+	return result, nil
+}
+
+// SetQueryTimeout limits how long queries made through the Service, such as GetVolumes, wait for
+// their results. Queries exceeding d fail with ErrQueryTimeout. A d of zero waits indefinitely,
+// which is the default.
+//
+// The timeout covers issuing the query and retrieving its results, not reading the properties of the
+// objects returned. A query which times out keeps running in the background until the provider
+// returns or gives up, and Close waits for it.
+//
+// Example:
+//		svc.SetQueryTimeout(30 * time.Second)
+func (svc *Service) SetQueryTimeout(d time.Duration) {
+	defer svc.lock()()
+	svc.queryTimeout = d
+}
+
+// A MetricsSink receives the duration of a WMI call, such as "ExecQuery" or "Volume.Format".
+type MetricsSink func(method string, d time.Duration)
+
+// SetMetricsSink configures fn to be called with the duration of each WMI query, and of the calls
+// made on objects retrieved through the Service. A nil fn disables metrics.
+//
+// fn is called synchronously, and should return quickly.
+//
+// Example:
+//		svc.SetMetricsSink(func(method string, d time.Duration) {
+//			logger.Infof("%s took %v", method, d)
+//		})
+func (svc *Service) SetMetricsSink(fn MetricsSink) {
+	if svc.metrics != nil {
+		svc.metrics.Store(fn)
+	}
+}
+
+// timeCall starts timing a call to method, and returns the function reporting its duration to the
+// MetricsSink, for use as defer svc.timeCall(method)(). It is safe to use on a nil Service.
+func (svc *Service) timeCall(method string) func() {
+	if svc == nil || svc.metrics == nil {
+		return func() {}
+	}
+	fn, _ := svc.metrics.Load().(MetricsSink)
+	if fn == nil {
+		return func() {}
+	}
+	start := time.Now()
+	return func() { fn(method, time.Since(start)) }
+}
+
+// lock acquires the Service mutex and returns the function releasing it, for use as
+// defer svc.lock()().
+func (svc *Service) lock() func() {
+	if svc.mu == nil {
+		return func() {}
+	}
+	svc.mu.Lock()
+	return svc.mu.Unlock
+}
+
+// setSecurity applies DCOM authentication and impersonation levels to a WMI scripting object.
+// Settings applied to the locator are inherited by the services it connects.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/wmisdk/swbemsecurity
+func (svc *Service) setSecurity(obj *ole.IDispatch, authLevel, impLevel uint32) error {
+	secRaw, err := svc.wmi().GetProperty(obj, "Security_")
+	if err != nil {
+		return fmt.Errorf("oleutil.GetProperty(Security_): %w", err)
+	}
+	sec := secRaw.ToIDispatch()
+	defer sec.Release()
+
+	if authLevel != AuthnLevelDefault {
+		if _, err := oleutil.PutProperty(sec, "AuthenticationLevel", int32(authLevel)); err != nil {
+			return fmt.Errorf("oleutil.PutProperty(AuthenticationLevel, %d): %w", authLevel, err)
+		}
+	}
+	if impLevel != 0 {
+		if _, err := oleutil.PutProperty(sec, "ImpersonationLevel", int32(impLevel)); err != nil {
+			return fmt.Errorf("oleutil.PutProperty(ImpersonationLevel, %d): %w", impLevel, err)
+		}
+	}
+	return nil
+}
+
+// Ping verifies that the WMI connection is still alive by retrieving a class definition from the
+// connected namespace. If Ping fails, the Service should be closed and a new connection established.
+//
+// Example:
+//		if err := svc.Ping(); err != nil {
+//			svc.Close()
+//			svc, err = storage.Connect()
+//		}
+func (svc *Service) Ping() error {
+	defer svc.lock()()
+	if svc.wmiSvc == nil {
+		return fmt.Errorf("Ping: not connected")
+	}
+	raw, err := svc.wmi().CallMethod(svc.wmiSvc, "Get", "__NAMESPACE")
+	if err != nil {
+		return fmt.Errorf("Ping: %w", err)
+	}
+	raw.ToIDispatch().Release()
+	return nil
+}
+
 // Close frees all resources associated with a volume.
+//
+// Close stops WatchVolumes and Volume.WatchFreeSpace, and waits for formats started by
+// Volume.StartFormat, Volume.FormatAsync and Volume.FormatContext, and for calls abandoned by
+// GetVolumesContext and Volume.OptimizeContext, to finish.
 func (svc *Service) Close() {
-	svc.wmiIntf.Release()
-	svc.wmiSvc.Release()
+	svc.stopBackground()
+	defer svc.lock()()
+	svc.clearCache()
+	if svc.wmiIntf != nil {
+		svc.wmiIntf.Release()
+	}
+	if svc.wmiSvc != nil {
+		svc.wmiSvc.Release()
+	}
 	comshim.Done()
 }
+
+// stopBackground closes done and waits for the goroutines started by background. They may need the
+// lock, so the caller must not hold it.
+func (svc *Service) stopBackground() {
+	if svc.doneOnce != nil {
+		svc.doneOnce.Do(func() { close(svc.done) })
+	}
+	if svc.pending != nil {
+		svc.pending.Wait()
+	}
+}
+
+// closed reports whether Close has been called.
+func (svc *Service) closed() bool {
+	select {
+	case <-svc.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// background runs fn in a new goroutine which Close waits for before releasing the connection.
+// Long running goroutines must return once done is closed.
+func (svc *Service) background(fn func()) {
+	if svc.pending == nil {
+		go fn()
+		return
+	}
+	svc.pending.Add(1)
+	go func() {
+		defer svc.pending.Done()
+		fn()
+	}()
+}
+
+// associators returns the objects of resultClass associated with the object behind handle through
+// assocClass, using the equivalent of an ASSOCIATORS OF query. Each returned handle must be released
+// by the caller.
+//
+// Example:
+//		d.svc.associators(d.handle, "MSFT_DiskToPartition", "MSFT_Partition")
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/wmisdk/swbemobject-associators-
+func (svc *Service) associators(handle *ole.IDispatch, assocClass, resultClass string) ([]*ole.IDispatch, error) {
+	if handle == nil {
+		return nil, fmt.Errorf("invalid handle")
+	}
+	raw, err := svc.wmi().CallMethod(handle, "Associators_", assocClass, resultClass)
+	if err != nil {
+		return nil, fmt.Errorf("Associators_(%s, %s): %w", assocClass, resultClass, err)
+	}
+	result := raw.ToIDispatch()
+	defer result.Release()
+
+	countVar, err := svc.wmi().GetProperty(result, "Count")
+	if err != nil {
+		return nil, fmt.Errorf("oleutil.GetProperty(Count): %w", err)
+	}
+	count := int(countVar.Val)
+
+	handles := make([]*ole.IDispatch, 0, count)
+	for i := 0; i < count; i++ {
+		itemRaw, err := svc.wmi().CallMethod(result, "ItemIndex", i)
+		if err != nil {
+			releaseAll(handles)
+			return nil, fmt.Errorf("oleutil.CallMethod(ItemIndex, %d): %w", i, err)
+		}
+		handles = append(handles, itemRaw.ToIDispatch())
+	}
+	return handles, nil
+}
+
+// associator returns the single object of resultClass associated with the object behind handle
+// through assocClass, or an error wrapping ErrNotFound if there is none. Any additional associated
+// objects are released. The returned handle must be released by the caller.
+func (svc *Service) associator(handle *ole.IDispatch, assocClass, resultClass string) (*ole.IDispatch, error) {
+	handles, err := svc.associators(handle, assocClass, resultClass)
+	if err != nil {
+		return nil, err
+	}
+	if len(handles) < 1 {
+		return nil, fmt.Errorf("no %s associated through %s: %w", resultClass, assocClass, ErrNotFound)
+	}
+	releaseAll(handles[1:])
+	return handles[0], nil
+}
+
+// itemIndex returns the item at index i of the result of query. An error naming the index and the
+// query is returned if the item is empty, rather than leaving a nil handle to fail later.
+func (svc *Service) itemIndex(result *ole.IDispatch, i int, query string) (*ole.IDispatch, error) {
+	itemRaw, err := svc.wmi().CallMethod(result, "ItemIndex", i)
+	if err != nil {
+		return nil, fmt.Errorf("oleutil.CallMethod(ItemIndex, %d) for %q: %w", i, query, err)
+	}
+	item := itemRaw.ToIDispatch()
+	if item == nil {
+		return nil, fmt.Errorf("ItemIndex(%d) returned an empty item for %q", i, query)
+	}
+	return item, nil
+}
+
+// execMethod calls method on the object behind handle with named input parameters, through
+// SWbemObject.ExecMethod_, and returns the output parameters object, which must be released by the
+// caller. Parameters which are not provided take the provider's defaults; this avoids depending on
+// the position of parameters of methods with many optional inputs.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/wmisdk/swbemobject-execmethod-
+func (svc *Service) execMethod(handle *ole.IDispatch, method string, params map[string]interface{}) (*ole.IDispatch, error) {
+	methodsRaw, err := svc.wmi().GetProperty(handle, "Methods_")
+	if err != nil {
+		return nil, fmt.Errorf("oleutil.GetProperty(Methods_): %w", err)
+	}
+	methods := methodsRaw.ToIDispatch()
+	defer methods.Release()
+	mRaw, err := svc.wmi().CallMethod(methods, "Item", method)
+	if err != nil {
+		return nil, fmt.Errorf("Methods_.Item(%s): %w", method, err)
+	}
+	m := mRaw.ToIDispatch()
+	defer m.Release()
+	inRaw, err := svc.wmi().GetProperty(m, "InParameters")
+	if err != nil {
+		return nil, fmt.Errorf("oleutil.GetProperty(InParameters): %w", err)
+	}
+	inClass := inRaw.ToIDispatch()
+	defer inClass.Release()
+	instRaw, err := svc.wmi().CallMethod(inClass, "SpawnInstance_")
+	if err != nil {
+		return nil, fmt.Errorf("SpawnInstance_: %w", err)
+	}
+	in := instRaw.ToIDispatch()
+	defer in.Release()
+	for name, val := range params {
+		if _, err := oleutil.PutProperty(in, name, val); err != nil {
+			return nil, fmt.Errorf("oleutil.PutProperty(%s): %w", name, err)
+		}
+	}
+	outRaw, err := svc.wmi().CallMethod(handle, "ExecMethod_", method, in)
+	if err != nil {
+		return nil, fmt.Errorf("ExecMethod_(%s): %w", method, err)
+	}
+	return outRaw.ToIDispatch(), nil
+}
+
+// getObject retrieves the object at the WMI object path, which must be released by the caller.
+func (svc *Service) getObject(path string) (*ole.IDispatch, error) {
+	defer svc.lock()()
+	raw, err := svc.wmi().CallMethod(svc.wmiSvc, "Get", path)
+	if err != nil {
+		return nil, fmt.Errorf("Get(%s): %w", path, err)
+	}
+	return raw.ToIDispatch(), nil
+}
+
+// releaseAll releases each of the provided handles.
+func releaseAll(handles []*ole.IDispatch) {
+	for _, h := range handles {
+		h.Release()
+	}
+}
+
+// COM error codes indicating a property is not exposed by an object.
+const (
+	dispEMemberNotFound = 0x80020003
+	dispEUnknownName    = 0x80020006
+	wbemENotFound       = 0x80041002
+)
+
+// isMissingProperty reports whether err indicates that a property is not supported by the object,
+// such as when the property was introduced in a later version of Windows.
+func isMissingProperty(err error) bool {
+	var oleErr *ole.OleError
+	if !errors.As(err, &oleErr) {
+		return false
+	}
+	switch oleErr.Code() {
+	case dispEMemberNotFound, dispEUnknownName, wbemENotFound:
+		return true
+	}
+	return false
+}
+
+// getProperty retrieves a property from a WMI object. Properties not supported on this system are
+// skipped with a warning, and returned as an empty value.
+func (svc *Service) getProperty(handle *ole.IDispatch, name string) (*ole.VARIANT, error) {
+	p, err := svc.wmi().GetProperty(handle, name)
+	if err != nil {
+		if isMissingProperty(err) {
+			logger.Warningf("property %s is not supported on this system: %v", name, err)
+			empty := ole.NewVariant(ole.VT_EMPTY, 0)
+			return &empty, nil
+		}
+		return nil, fmt.Errorf("oleutil.GetProperty(%s): %w", name, err)
+	}
+	return p, nil
+}
+
+// objectPath returns the WMI object path (__PATH) of the object behind handle.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/wmisdk/swbemobject-path-
+func (svc *Service) objectPath(handle *ole.IDispatch) (string, error) {
+	raw, err := svc.wmi().GetProperty(handle, "Path_")
+	if err != nil {
+		return "", fmt.Errorf("oleutil.GetProperty(Path_): %w", err)
+	}
+	path := raw.ToIDispatch()
+	defer path.Release()
+
+	p, err := svc.wmi().GetProperty(path, "Path")
+	if err != nil {
+		return "", fmt.Errorf("oleutil.GetProperty(Path): %w", err)
+	}
+	return p.ToString(), nil
+}
+
+// refreshObject re-reads the properties of the WMI object behind handle from the provider.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/wmisdk/swbemobjectex-refresh-
+func (svc *Service) refreshObject(handle *ole.IDispatch) error {
+	if _, err := svc.wmi().CallMethod(handle, "Refresh_"); err != nil {
+		return fmt.Errorf("Refresh_: %w", err)
+	}
+	return nil
+}
+
+// sizeFilter builds a WQL filter matching objects whose Size is within [min, max].
+// A max of zero leaves the range unbounded above.
+func sizeFilter(min, max uint64) (string, error) {
+	if max == 0 {
+		return fmt.Sprintf("WHERE Size >= %d", min), nil
+	}
+	if min > max {
+		return "", fmt.Errorf("invalid size range: min %d is greater than max %d", min, max)
+	}
+	return fmt.Sprintf("WHERE Size >= %d AND Size <= %d", min, max), nil
+}
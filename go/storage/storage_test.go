@@ -0,0 +1,98 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSizeFilter(t *testing.T) {
+	tests := []struct {
+		min     uint64
+		max     uint64
+		want    string
+		wantErr bool
+	}{
+		{0, 0, "WHERE Size >= 0", false},
+		{1024, 0, "WHERE Size >= 1024", false},
+		{1024, 4096, "WHERE Size >= 1024 AND Size <= 4096", false},
+		{4096, 4096, "WHERE Size >= 4096 AND Size <= 4096", false},
+		{4096, 1024, "", true},
+	}
+	for _, tt := range tests {
+		got, err := sizeFilter(tt.min, tt.max)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("sizeFilter(%d, %d) returned unexpected error %v", tt.min, tt.max, err)
+		}
+		if got != tt.want {
+			t.Errorf("sizeFilter(%d, %d) = %q, want %q", tt.min, tt.max, got, tt.want)
+		}
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	svc := &Service{dryRun: &atomic.Value{}}
+	svc.DryRun(true)
+	// With a nil handle, anything but the dry run path fails.
+	p := Partition{DiskNumber: 1, PartitionNumber: 2, svc: svc}
+	if _, err := p.Delete(); err != nil {
+		t.Errorf("Delete() in dry run mode returned %v", err)
+	}
+	d := Disk{Number: 1, svc: svc}
+	if _, err := d.Initialize(GptStyle); err != nil {
+		t.Errorf("Initialize() in dry run mode returned %v", err)
+	}
+	if _, err := d.Offline(); err != nil {
+		t.Errorf("Offline() in dry run mode returned %v", err)
+	}
+	if _, err := p.RemoveAccessPath("D:"); err != nil {
+		t.Errorf("RemoveAccessPath() in dry run mode returned %v", err)
+	}
+	if err := svc.RemoveMountPoint(`C:\mnt\data`); err != nil {
+		t.Errorf("RemoveMountPoint() in dry run mode returned %v", err)
+	}
+
+	// Guards still apply in dry run mode.
+	p.IsSystem = true
+	if _, err := p.Delete(); err == nil {
+		t.Errorf("Delete() of the system partition in dry run mode succeeded")
+	}
+}
+
+func TestCheckLocal(t *testing.T) {
+	if err := (&Service{}).checkLocal(); err != nil {
+		t.Errorf("checkLocal() for the local machine returned %v", err)
+	}
+	var nilSvc *Service
+	if err := nilSvc.checkLocal(); err != nil {
+		t.Errorf("checkLocal() on a nil Service returned %v", err)
+	}
+	if err := (&Service{host: "host1"}).checkLocal(); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("checkLocal() for a remote host returned %v, want %v", err, ErrNotSupported)
+	}
+
+	v := Volume{svc: &Service{host: "host1"}, DriveLetter: "C"}
+	if err := v.MountAt(`C:\mnt\data`); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("MountAt() for a remote host returned %v, want %v", err, ErrNotSupported)
+	}
+	if err := v.RemountAt(`C:\mnt\data`, `C:\data`); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("RemountAt() for a remote host returned %v, want %v", err, ErrNotSupported)
+	}
+	if _, err := v.GetLargestDirectories(5); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("GetLargestDirectories() for a remote host returned %v, want %v", err, ErrNotSupported)
+	}
+}
@@ -0,0 +1,218 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"fmt"
+
+	"github.com/google/logger"
+	"github.com/go-ole/go-ole"
+)
+
+// StoragePool represents a MSFT_StoragePool object, a Storage Spaces pool from which virtual disks
+// are created.
+//
+// Ref: https://docs.microsoft.com/en-us/previous-versions/windows/desktop/stormgmt/msft-storagepool
+type StoragePool struct {
+	UniqueID      string
+	FriendlyName  string
+	Size          uint64
+	AllocatedSize uint64
+	HealthStatus  int32
+	IsReadOnly    bool
+	IsPrimordial  bool
+
+	handle *ole.IDispatch
+	svc    *Service
+}
+
+// Close releases the handle to the storage pool.
+func (p *StoragePool) Close() {
+	if p.handle != nil {
+		p.handle.Release()
+	}
+}
+
+// CreateVirtualDisk creates a virtual disk of size bytes in the pool, or using all remaining space if
+// size is zero. An empty resiliency or a ProvisioningUnknown provisioning type leaves the choice to
+// the pool's defaults.
+//
+// If successful, the virtual disk is returned as a new VirtualDisk object. The new VirtualDisk must be Closed().
+//
+// Example:
+//		vd, _, err := pool.CreateVirtualDisk("Data", 0, storage.ResiliencyMirror, storage.ProvisioningFixed)
+//
+// Ref: https://docs.microsoft.com/en-us/previous-versions/windows/desktop/stormgmt/createvirtualdisk-msft-storagepool
+func (p *StoragePool) CreateVirtualDisk(friendlyName string, size uint64, resiliency ResiliencySetting,
+	provisioning ProvisioningType) (VirtualDisk, ExtendedStatus, error) {
+	vd := VirtualDisk{svc: p.svc}
+	stat := ExtendedStatus{}
+	if p.IsPrimordial {
+		return vd, stat, fmt.Errorf("CreateVirtualDisk: virtual disks cannot be created in the primordial pool")
+	}
+	if resiliency != "" && !resiliency.Valid() {
+		return vd, stat, fmt.Errorf("CreateVirtualDisk: invalid resiliency setting %q", resiliency)
+	}
+	if provisioning != ProvisioningUnknown && !provisioning.Valid() {
+		return vd, stat, fmt.Errorf("CreateVirtualDisk: invalid provisioning type %v", provisioning)
+	}
+	if p.svc == nil {
+		return vd, stat, fmt.Errorf("CreateVirtualDisk: storage pool is not bound to a Service")
+	}
+	if p.svc.skipDryRun("StoragePool.CreateVirtualDisk(%s, %q, %d)", p.FriendlyName, friendlyName, size) {
+		return vd, stat, nil
+	}
+
+	params := map[string]interface{}{"FriendlyName": friendlyName}
+	if size > 0 {
+		// uint64 values are passed to WMI as strings.
+		params["Size"] = fmt.Sprintf("%d", size)
+	} else {
+		params["UseMaximumSize"] = true
+	}
+	if resiliency != "" {
+		params["ResiliencySettingName"] = resiliency.String()
+	}
+	if provisioning != ProvisioningUnknown {
+		params["ProvisioningType"] = int32(provisioning)
+	}
+
+	out, err := p.svc.execMethod(p.handle, "CreateVirtualDisk", params)
+	if err != nil {
+		return vd, stat, fmt.Errorf("CreateVirtualDisk: %w", err)
+	}
+	defer out.Release()
+	if es, err := p.svc.getProperty(out, "ExtendedStatus"); err == nil {
+		stat = p.svc.newExtendedStatus(es)
+	}
+	ret, err := p.svc.getProperty(out, "ReturnValue")
+	if err != nil {
+		return vd, stat, fmt.Errorf("CreateVirtualDisk: %w", err)
+	} else if val, ok := ret.Value().(int32); val != 0 || !ok {
+		return vd, stat, &StorageError{"virtual disk creation", val}
+	}
+
+	created, err := p.svc.getProperty(out, "CreatedVirtualDisk")
+	if err != nil {
+		return vd, stat, fmt.Errorf("CreateVirtualDisk: %w", err)
+	}
+	vd.handle = created.ToIDispatch()
+	if vd.handle == nil {
+		return vd, stat, fmt.Errorf("CreateVirtualDisk: no virtual disk was returned")
+	}
+	return vd, stat, vd.Query()
+}
+
+// Query reads and populates the storage pool state.
+func (p *StoragePool) Query() error {
+	if p.handle == nil {
+		return fmt.Errorf("invalid handle")
+	}
+
+	for _, pr := range [][]interface{}{
+		[]interface{}{"UniqueId", &p.UniqueID},
+		[]interface{}{"FriendlyName", &p.FriendlyName},
+		[]interface{}{"Size", &p.Size},
+		[]interface{}{"AllocatedSize", &p.AllocatedSize},
+		[]interface{}{"HealthStatus", &p.HealthStatus},
+		[]interface{}{"IsReadOnly", &p.IsReadOnly},
+		[]interface{}{"IsPrimordial", &p.IsPrimordial},
+	} {
+		prop, err := p.svc.getProperty(p.handle, pr[0].(string))
+		if err != nil {
+			return err
+		}
+		if err := assignVariant(prop.Value(), pr[1]); err != nil {
+			logger.Warningf("assignVariant(%s): %v", pr[0].(string), err)
+		}
+	}
+	return nil
+}
+
+// A StoragePoolSet contains one or more StoragePools.
+type StoragePoolSet struct {
+	Pools []StoragePool
+}
+
+// Close releases all StoragePool handles inside a StoragePoolSet.
+func (s *StoragePoolSet) Close() {
+	for _, p := range s.Pools {
+		p.Close()
+	}
+}
+
+// WithoutPrimordial returns the pools in the set other than primordial pools. The primordial pool
+// holds the physical disks not yet added to a concrete pool, and cannot be used to create virtual disks.
+//
+// The resulting StoragePoolSet holds its own references to the pools, and must be closed
+// independently of the original.
+func (s *StoragePoolSet) WithoutPrimordial() StoragePoolSet {
+	filtered := StoragePoolSet{}
+	for _, p := range s.Pools {
+		if p.IsPrimordial {
+			continue
+		}
+		if p.handle != nil {
+			p.handle.AddRef()
+		}
+		filtered.Pools = append(filtered.Pools, p)
+	}
+	return filtered
+}
+
+// GetStoragePools queries for storage pools.
+//
+// Close() must be called on the resulting StoragePoolSet to ensure all pools are released.
+//
+// Get all storage pools:
+//		svc.GetStoragePools("")
+//
+// To get specific pools, provide a valid WMI query filter string, for example, to exclude the primordial pool:
+//		svc.GetStoragePools("WHERE IsPrimordial=False")
+func (svc *Service) GetStoragePools(filter string) (StoragePoolSet, error) {
+	defer svc.lock()()
+	pset := StoragePoolSet{}
+	query := "SELECT * FROM MSFT_StoragePool"
+	if filter != "" {
+		query = fmt.Sprintf("%s %s", query, filter)
+	}
+	result, err := svc.execQuery(query)
+	if err != nil {
+		return pset, err
+	}
+	defer result.Release()
+
+	countVar, err := svc.wmi().GetProperty(result, "Count")
+	if err != nil {
+		return pset, fmt.Errorf("oleutil.GetProperty(Count): %w", err)
+	}
+	count := int(countVar.Val)
+
+	for i := 0; i < count; i++ {
+		p := StoragePool{}
+		item, err := svc.itemIndex(result, i, query)
+		if err != nil {
+			return pset, err
+		}
+		p.handle = item
+		p.svc = svc
+		if err := p.Query(); err != nil {
+			return pset, err
+		}
+		pset.Pools = append(pset.Pools, p)
+	}
+
+	return pset, nil
+}
@@ -0,0 +1,170 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"fmt"
+
+	"github.com/google/logger"
+	"github.com/go-ole/go-ole"
+)
+
+// StorageSubsystem represents a MSFT_StorageSubSystem object.
+//
+// Ref: https://docs.microsoft.com/en-us/previous-versions/windows/desktop/stormgmt/msft-storagesubsystem
+type StorageSubsystem struct {
+	UniqueID                   string
+	FriendlyName               string
+	Name                       string
+	Model                      string
+	Manufacturer               string
+	SerialNumber               string
+	HealthStatus               int32
+	AutomaticClusteringEnabled bool
+
+	handle *ole.IDispatch
+	svc    *Service
+}
+
+// Close releases the handle to the storage subsystem.
+func (s *StorageSubsystem) Close() {
+	if s.handle != nil {
+		s.handle.Release()
+	}
+}
+
+// GetDisks returns the disks managed by the storage subsystem.
+//
+// Close() must be called on the resulting DiskSet to ensure all disks are released.
+func (s *StorageSubsystem) GetDisks() (DiskSet, error) {
+	dset := DiskSet{}
+	handles, err := s.svc.associators(s.handle, "MSFT_StorageSubSystemToDisk", "MSFT_Disk")
+	if err != nil {
+		return dset, err
+	}
+	for i, h := range handles {
+		d := Disk{handle: h, svc: s.svc}
+		if err := d.Query(); err != nil {
+			releaseAll(handles[i:])
+			return dset, err
+		}
+		dset.Disks = append(dset.Disks, d)
+	}
+	return dset, nil
+}
+
+// GetVolumes returns the volumes managed by the storage subsystem.
+//
+// Close() must be called on the resulting VolumeSet to ensure all volumes are released.
+func (s *StorageSubsystem) GetVolumes() (VolumeSet, error) {
+	vset := VolumeSet{}
+	handles, err := s.svc.associators(s.handle, "MSFT_StorageSubSystemToVolume", "MSFT_Volume")
+	if err != nil {
+		return vset, err
+	}
+	for i, h := range handles {
+		v := Volume{handle: h, svc: s.svc}
+		if err := v.Query(); err != nil {
+			releaseAll(handles[i:])
+			return vset, err
+		}
+		vset.Volumes = append(vset.Volumes, v)
+	}
+	return vset, nil
+}
+
+// Query reads and populates the storage subsystem state.
+func (s *StorageSubsystem) Query() error {
+	if s.handle == nil {
+		return fmt.Errorf("invalid handle")
+	}
+
+	for _, p := range [][]interface{}{
+		[]interface{}{"UniqueId", &s.UniqueID},
+		[]interface{}{"FriendlyName", &s.FriendlyName},
+		[]interface{}{"Name", &s.Name},
+		[]interface{}{"Model", &s.Model},
+		[]interface{}{"Manufacturer", &s.Manufacturer},
+		[]interface{}{"SerialNumber", &s.SerialNumber},
+		[]interface{}{"HealthStatus", &s.HealthStatus},
+		[]interface{}{"AutomaticClusteringEnabled", &s.AutomaticClusteringEnabled},
+	} {
+		prop, err := s.svc.getProperty(s.handle, p[0].(string))
+		if err != nil {
+			return err
+		}
+		if err := assignVariant(prop.Value(), p[1]); err != nil {
+			logger.Warningf("assignVariant(%s): %v", p[0].(string), err)
+		}
+	}
+	return nil
+}
+
+// A StorageSubsystemSet contains one or more StorageSubsystems.
+type StorageSubsystemSet struct {
+	Subsystems []StorageSubsystem
+}
+
+// Close releases all StorageSubsystem handles inside a StorageSubsystemSet.
+func (s *StorageSubsystemSet) Close() {
+	for _, ss := range s.Subsystems {
+		ss.Close()
+	}
+}
+
+// GetStorageSubsystems queries for local storage subsystems.
+//
+// Close() must be called on the resulting StorageSubsystemSet to ensure all subsystems are released.
+//
+// Get all storage subsystems:
+//		svc.GetStorageSubsystems("")
+//
+// To get specific subsystems, provide a valid WMI query filter string, for example:
+//		svc.GetStorageSubsystems("WHERE AutomaticClusteringEnabled=True")
+func (svc *Service) GetStorageSubsystems(filter string) (StorageSubsystemSet, error) {
+	defer svc.lock()()
+	sset := StorageSubsystemSet{}
+	query := "SELECT * FROM MSFT_StorageSubSystem"
+	if filter != "" {
+		query = fmt.Sprintf("%s %s", query, filter)
+	}
+	result, err := svc.execQuery(query)
+	if err != nil {
+		return sset, err
+	}
+	defer result.Release()
+
+	countVar, err := svc.wmi().GetProperty(result, "Count")
+	if err != nil {
+		return sset, fmt.Errorf("oleutil.GetProperty(Count): %w", err)
+	}
+	count := int(countVar.Val)
+
+	for i := 0; i < count; i++ {
+		s := StorageSubsystem{}
+		item, err := svc.itemIndex(result, i, query)
+		if err != nil {
+			return sset, err
+		}
+		s.handle = item
+		s.svc = svc
+		if err := s.Query(); err != nil {
+			return sset, err
+		}
+		sset.Subsystems = append(sset.Subsystems, s)
+	}
+
+	return sset, nil
+}
@@ -0,0 +1,230 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"fmt"
+
+	"github.com/google/logger"
+	"github.com/go-ole/go-ole"
+)
+
+// VirtualDisk represents a MSFT_VirtualDisk object.
+//
+// Ref: https://docs.microsoft.com/en-us/previous-versions/windows/desktop/stormgmt/msft-virtualdisk
+type VirtualDisk struct {
+	UniqueID              string
+	FriendlyName          string
+	Name                  string
+	ResiliencySettingName string
+	Size                  uint64
+	AllocatedSize         uint64
+	HealthStatus          int32
+	ProvisioningType      int32
+	NumberOfDataCopies    int32
+	IsSnapshot            bool
+
+	handle *ole.IDispatch
+	svc    *Service
+}
+
+// ProvisioningType represents how the storage for a virtual disk is allocated.
+type ProvisioningType int32
+
+const (
+	// ProvisioningUnknown indicates the provisioning type is not known.
+	ProvisioningUnknown ProvisioningType = 0
+	// ProvisioningThin allocates storage from the pool on demand, as data is written.
+	ProvisioningThin ProvisioningType = 1
+	// ProvisioningFixed allocates all storage from the pool when the virtual disk is created.
+	ProvisioningFixed ProvisioningType = 2
+)
+
+// String returns the name of the provisioning type.
+func (pt ProvisioningType) String() string {
+	switch pt {
+	case ProvisioningUnknown:
+		return "Unknown"
+	case ProvisioningThin:
+		return "Thin"
+	case ProvisioningFixed:
+		return "Fixed"
+	}
+	return fmt.Sprintf("Unknown(%d)", int32(pt))
+}
+
+// Valid reports whether pt is one of the provisioning types a virtual disk can be created with.
+func (pt ProvisioningType) Valid() bool {
+	switch pt {
+	case ProvisioningThin, ProvisioningFixed:
+		return true
+	}
+	return false
+}
+
+// ResiliencySetting represents the storage layout used to protect the data of a virtual disk.
+type ResiliencySetting string
+
+const (
+	// ResiliencySimple stripes data across disks without redundancy.
+	ResiliencySimple ResiliencySetting = "Simple"
+	// ResiliencyMirror keeps multiple copies of the data on separate disks.
+	ResiliencyMirror ResiliencySetting = "Mirror"
+	// ResiliencyParity stripes data and parity information across disks.
+	ResiliencyParity ResiliencySetting = "Parity"
+)
+
+// String returns the name of the resiliency setting, as used by ResiliencySettingName.
+func (rs ResiliencySetting) String() string {
+	return string(rs)
+}
+
+// Valid reports whether rs is one of the resiliency settings supported by Storage Spaces.
+func (rs ResiliencySetting) Valid() bool {
+	switch rs {
+	case ResiliencySimple, ResiliencyMirror, ResiliencyParity:
+		return true
+	}
+	return false
+}
+
+// Provisioning returns the provisioning type of the virtual disk.
+func (v *VirtualDisk) Provisioning() ProvisioningType {
+	return ProvisioningType(v.ProvisioningType)
+}
+
+// Resiliency returns the resiliency setting of the virtual disk.
+func (v *VirtualDisk) Resiliency() ResiliencySetting {
+	return ResiliencySetting(v.ResiliencySettingName)
+}
+
+// Close releases the handle to the virtual disk.
+func (v *VirtualDisk) Close() {
+	if v.handle != nil {
+		v.handle.Release()
+	}
+}
+
+// GetDisk returns the disk exposed by the virtual disk.
+//
+// Close() must be called on the resulting Disk.
+func (v *VirtualDisk) GetDisk() (Disk, error) {
+	d := Disk{svc: v.svc}
+	h, err := v.svc.associator(v.handle, "MSFT_VirtualDiskToDisk", "MSFT_Disk")
+	if err != nil {
+		return d, fmt.Errorf("GetDisk(%s): %w", v.FriendlyName, err)
+	}
+	d.handle = h
+	return d, d.Query()
+}
+
+// GetVirtualDisk returns the virtual disk backing a Storage Spaces disk.
+//
+// Close() must be called on the resulting VirtualDisk.
+func (d *Disk) GetVirtualDisk() (VirtualDisk, error) {
+	v := VirtualDisk{svc: d.svc}
+	h, err := d.svc.associator(d.handle, "MSFT_VirtualDiskToDisk", "MSFT_VirtualDisk")
+	if err != nil {
+		return v, fmt.Errorf("GetVirtualDisk(%d): %w", d.Number, err)
+	}
+	v.handle = h
+	return v, v.Query()
+}
+
+// Query reads and populates the virtual disk state.
+func (v *VirtualDisk) Query() error {
+	if v.handle == nil {
+		return fmt.Errorf("invalid handle")
+	}
+
+	for _, p := range [][]interface{}{
+		[]interface{}{"UniqueId", &v.UniqueID},
+		[]interface{}{"FriendlyName", &v.FriendlyName},
+		[]interface{}{"Name", &v.Name},
+		[]interface{}{"ResiliencySettingName", &v.ResiliencySettingName},
+		[]interface{}{"Size", &v.Size},
+		[]interface{}{"AllocatedSize", &v.AllocatedSize},
+		[]interface{}{"HealthStatus", &v.HealthStatus},
+		[]interface{}{"ProvisioningType", &v.ProvisioningType},
+		[]interface{}{"NumberOfDataCopies", &v.NumberOfDataCopies},
+		[]interface{}{"IsSnapshot", &v.IsSnapshot},
+	} {
+		prop, err := v.svc.getProperty(v.handle, p[0].(string))
+		if err != nil {
+			return err
+		}
+		if err := assignVariant(prop.Value(), p[1]); err != nil {
+			logger.Warningf("assignVariant(%s): %v", p[0].(string), err)
+		}
+	}
+	return nil
+}
+
+// A VirtualDiskSet contains one or more VirtualDisks.
+type VirtualDiskSet struct {
+	VirtualDisks []VirtualDisk
+}
+
+// Close releases all VirtualDisk handles inside a VirtualDiskSet.
+func (s *VirtualDiskSet) Close() {
+	for _, v := range s.VirtualDisks {
+		v.Close()
+	}
+}
+
+// GetVirtualDisks queries for local virtual disks.
+//
+// Close() must be called on the resulting VirtualDiskSet to ensure all virtual disks are released.
+//
+// Get all virtual disks:
+//		svc.GetVirtualDisks("")
+//
+// To get specific virtual disks, provide a valid WMI query filter string, for example:
+//		svc.GetVirtualDisks("WHERE FriendlyName='Data'")
+func (svc *Service) GetVirtualDisks(filter string) (VirtualDiskSet, error) {
+	defer svc.lock()()
+	vset := VirtualDiskSet{}
+	query := "SELECT * FROM MSFT_VirtualDisk"
+	if filter != "" {
+		query = fmt.Sprintf("%s %s", query, filter)
+	}
+	result, err := svc.execQuery(query)
+	if err != nil {
+		return vset, err
+	}
+	defer result.Release()
+
+	countVar, err := svc.wmi().GetProperty(result, "Count")
+	if err != nil {
+		return vset, fmt.Errorf("oleutil.GetProperty(Count): %w", err)
+	}
+	count := int(countVar.Val)
+
+	for i := 0; i < count; i++ {
+		v := VirtualDisk{}
+		item, err := svc.itemIndex(result, i, query)
+		if err != nil {
+			return vset, err
+		}
+		v.handle = item
+		v.svc = svc
+		if err := v.Query(); err != nil {
+			return vset, err
+		}
+		vset.VirtualDisks = append(vset.VirtualDisks, v)
+	}
+
+	return vset, nil
+}
@@ -0,0 +1,46 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"testing"
+)
+
+func TestProvisioningTypeValid(t *testing.T) {
+	tests := []struct {
+		in   ProvisioningType
+		want bool
+	}{
+		{ProvisioningThin, true},
+		{ProvisioningFixed, true},
+		{ProvisioningUnknown, false},
+		{ProvisioningType(3), false},
+	}
+	for _, tt := range tests {
+		if got := tt.in.Valid(); got != tt.want {
+			t.Errorf("%v.Valid() = %t, want %t", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCreateVirtualDiskInvalid(t *testing.T) {
+	pool := StoragePool{FriendlyName: "Pool", svc: &Service{}}
+	if _, _, err := pool.CreateVirtualDisk("Data", 0, ResiliencyMirror, ProvisioningType(3)); err == nil {
+		t.Errorf("CreateVirtualDisk() with provisioning type 3 succeeded, want an error")
+	}
+	if _, _, err := pool.CreateVirtualDisk("Data", 0, ResiliencySetting("Striped"), ProvisioningFixed); err == nil {
+		t.Errorf("CreateVirtualDisk() with resiliency Striped succeeded, want an error")
+	}
+}
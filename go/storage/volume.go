@@ -15,16 +15,28 @@
 package storage
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
 
 	"github.com/google/logger"
 	"github.com/go-ole/go-ole"
-	"github.com/go-ole/go-ole/oleutil"
 )
 
 // Volume represents a MSFT_Volume object.
 //
+// A Volume owns one reference to its WMI handle, which Close releases. Copying a Volume value does
+// not take a new reference, so exactly one copy must be closed; use Clone to pass a volume to another
+// owner, such as another goroutine, which closes its clone independently.
+//
 // Ref: https://docs.microsoft.com/en-us/previous-versions/windows/desktop/stormgmt/msft-volume
 type Volume struct {
 	DriveLetter     string
@@ -38,25 +50,323 @@ type Volume struct {
 	DriveType       int32
 	DedupMode       int32
 
-	handle *ole.IDispatch
+	handle     *ole.IDispatch
+	objectPath string
+	svc        *Service
+
+	// offlinePartition is the object path of the partition taken offline by Dismount.
+	offlinePartition string
+
+	protectionKnown bool
+	isSystem        bool
+	isBoot          bool
+}
+
+// CaptureImage captures the contents of the volume into a WIM image at destPath using DISM.
+//
+// The image is named after the volume label. The volume must have a drive letter or a volume GUID
+// path that DISM can read from.
+//
+// Example:
+//		v.CaptureImage(`D:\images\data.wim`)
+//
+// Ref: https://docs.microsoft.com/en-us/windows-hardware/manufacture/desktop/dism-image-management-command-line-options-s14
+func (v *Volume) CaptureImage(destPath string) error {
+	if err := v.svc.checkLocal(); err != nil {
+		return fmt.Errorf("CaptureImage: %w", err)
+	}
+	root := v.rootPath()
+	if root == "" {
+		return fmt.Errorf("CaptureImage: volume has no accessible path")
+	}
+	name := v.FileSystemLabel
+	if name == "" {
+		name = "Volume"
+	}
+
+	args := []string{
+		"/Capture-Image",
+		"/ImageFile:" + destPath,
+		"/CaptureDir:" + root,
+		"/Name:" + name,
+	}
+	if _, err := fnExec(dismExe, args, nil); err != nil {
+		return fmt.Errorf("CaptureImage(%s): %w", destPath, err)
+	}
+	return nil
+}
+
+// Clone returns a copy of the volume holding its own reference to the volume handle. The clone and
+// the original must each be closed.
+//
+// Example:
+//		c := v.Clone()
+//		go func() {
+//			defer c.Close()
+//			c.Optimize(true, false, false, false, false)
+//		}()
+func (v *Volume) Clone() Volume {
+	c := *v
+	if c.handle != nil {
+		c.handle.AddRef()
+	}
+	return c
 }
 
-// Close releases the handle to the volume.
+// Close releases the handle to the volume. Calling Close more than once on the same Volume is safe.
 func (v *Volume) Close() {
 	if v.handle != nil {
 		v.handle.Release()
+		v.handle = nil
+	}
+}
+
+// rootPath returns the path of the volume's root directory: its drive letter (C:\) if it has one,
+// or its volume GUID path otherwise.
+func (v *Volume) rootPath() string {
+	if v.DriveLetter != "" {
+		return v.DriveLetter + `:\`
+	}
+	return v.Path
+}
+
+// ClusterSize returns the volume's allocation unit size, in bytes per cluster.
+func (v *Volume) ClusterSize() (uint32, error) {
+	if err := v.svc.checkLocal(); err != nil {
+		return 0, fmt.Errorf("ClusterSize: %w", err)
+	}
+	root := v.rootPath()
+	size, err := clusterSize(root)
+	if err != nil {
+		return 0, fmt.Errorf("ClusterSize: %w", err)
+	}
+	return size, nil
+}
+
+// Diagnosis summarizes the health of a volume.
+type Diagnosis struct {
+	DriveLetter       string
+	Path              string
+	HealthStatus      int32
+	OperationalStatus []int32
+	CorruptionCount   uint32
+	Dirty             bool
+	// Summary is a human readable description of any problems found.
+	Summary string
+}
+
+// Diagnose refreshes the volume state and gathers its health indicators into a single Diagnosis.
+//
+// Example:
+//		d, err := v.Diagnose()
+//		logger.Infof("volume %s: %s", d.Path, d.Summary)
+func (v *Volume) Diagnose() (Diagnosis, error) {
+	if err := v.svc.checkLocal(); err != nil {
+		return Diagnosis{}, fmt.Errorf("Diagnose: %w", err)
+	}
+	diag := Diagnosis{}
+	if err := v.Query(); err != nil {
+		return diag, err
+	}
+	diag.DriveLetter = v.DriveLetter
+	diag.Path = v.Path
+	diag.HealthStatus = v.HealthStatus
+
+	p, err := v.svc.wmi().GetProperty(v.handle, "OperationalStatus")
+	if err != nil {
+		return diag, fmt.Errorf("oleutil.GetProperty(OperationalStatus): %w", err)
+	}
+	if arr := p.ToArray(); arr != nil {
+		for _, s := range arr.ToValueArray() {
+			if i, ok := s.(int32); ok {
+				diag.OperationalStatus = append(diag.OperationalStatus, i)
+			}
+		}
+	}
+
+	var corruptionCount ole.VARIANT
+	ole.VariantInit(&corruptionCount)
+	res, err := v.svc.wmi().CallMethod(v.handle, "GetCorruptionCount", &corruptionCount)
+	if err != nil {
+		return diag, fmt.Errorf("GetCorruptionCount: %w", err)
+	} else if val, ok := res.Value().(int32); val != 0 || !ok {
+		return diag, &StorageError{"corruption count", val}
+	}
+	if c, ok := corruptionCount.Value().(int32); ok {
+		diag.CorruptionCount = uint32(c)
+	}
+
+	if diag.Dirty, err = volumeDirty(v.Path); err != nil {
+		return diag, err
+	}
+
+	var problems []string
+	if diag.HealthStatus != 0 {
+		problems = append(problems, fmt.Sprintf("health status is %d", diag.HealthStatus))
+	}
+	for _, s := range diag.OperationalStatus {
+		// 2 is OK
+		if s != 2 {
+			problems = append(problems, fmt.Sprintf("operational status is %d", s))
+		}
+	}
+	if diag.CorruptionCount > 0 {
+		problems = append(problems, fmt.Sprintf("%d corruptions detected", diag.CorruptionCount))
+	}
+	if diag.Dirty {
+		problems = append(problems, "dirty bit is set")
+	}
+	if len(problems) == 0 {
+		diag.Summary = "healthy"
+	} else {
+		diag.Summary = strings.Join(problems, "; ")
+	}
+	return diag, nil
+}
+
+// DevicePath returns the volume's device path (\\?\Volume{GUID}), which can be passed to tools
+// and APIs that operate on the volume device, such as DISM or CreateFile.
+func (v *Volume) DevicePath() string {
+	return strings.TrimSuffix(v.Path, `\`)
+}
+
+// GUID returns the volume GUID, such as {2e2c0316-ec35-4ef0-8387-7d2a4cc8e0c5}, taken from the
+// volume path. It returns an empty string if the path is not a volume GUID path.
+func (v *Volume) GUID() string {
+	start := strings.Index(v.Path, "{")
+	end := strings.Index(v.Path, "}")
+	if start < 0 || end < start {
+		return ""
+	}
+	return v.Path[start : end+1]
+}
+
+// Eject dismounts the volume and ejects its media. Only optical and removable drives can be ejected;
+// Eject fails with ErrVolumeInUse if the volume has open handles.
+//
+// Example:
+//		drives, err := svc.GetOpticalDrives()
+//		defer drives.Close()
+//		for _, d := range drives.Volumes {
+//			d.Eject()
+//		}
+func (v *Volume) Eject() error {
+	if err := v.svc.checkLocal(); err != nil {
+		return fmt.Errorf("Eject: %w", err)
+	}
+	// DriveType 2 is Removable, 5 is CDROM
+	if v.DriveType != 2 && v.DriveType != 5 {
+		return fmt.Errorf("Eject: volume is not removable, drive type is %s", enumName(driveTypeNames, v.DriveType))
 	}
+	if v.svc.skipDryRun("Volume.Eject(%s)", v.Path) {
+		return nil
+	}
+	if err := ejectMedia(v.Path); err != nil {
+		return fmt.Errorf("Eject: %w", err)
+	}
+	return nil
+}
+
+// EnableCompression enables or disables NTFS compression for the volume. The setting is applied to the
+// root directory, and is inherited by files and directories created afterwards; existing files keep
+// their current state.
+func (v *Volume) EnableCompression(enable bool) error {
+	if err := v.svc.checkLocal(); err != nil {
+		return fmt.Errorf("EnableCompression: %w", err)
+	}
+	if !strings.EqualFold("NTFS", v.FileSystem) {
+		return fmt.Errorf("EnableCompression: compression requires NTFS, volume is %q", v.FileSystem)
+	}
+	if err := setCompression(v.rootPath(), enable); err != nil {
+		return fmt.Errorf("EnableCompression(%t): %w", enable, err)
+	}
+	return nil
+}
+
+// FSFeatures describes the capabilities of a volume's file system.
+type FSFeatures struct {
+	CaseSensitive    bool
+	CasePreserved    bool
+	Unicode          bool
+	PersistentACLs   bool
+	Compression      bool
+	Quotas           bool
+	SparseFiles      bool
+	ReparsePoints    bool
+	Compressed       bool
+	ObjectIDs        bool
+	Encryption       bool
+	ReadOnly         bool
+	IntegrityStreams bool
+}
+
+// File system flags reported by GetVolumeInformation.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/api/fileapi/nf-fileapi-getvolumeinformationw
+const (
+	fileCaseSensitiveSearch      = 0x00000001
+	fileCasePreservedNames       = 0x00000002
+	fileUnicodeOnDisk            = 0x00000004
+	filePersistentACLs           = 0x00000008
+	fileFileCompression          = 0x00000010
+	fileVolumeQuotas             = 0x00000020
+	fileSupportsSparseFiles      = 0x00000040
+	fileSupportsReparsePoints    = 0x00000080
+	fileVolumeIsCompressed       = 0x00008000
+	fileSupportsObjectIDs        = 0x00010000
+	fileSupportsEncryption       = 0x00020000
+	fileReadOnlyVolume           = 0x00080000
+	fileSupportsIntegrityStreams = 0x04000000
+)
+
+// newFSFeatures decodes GetVolumeInformation file system flags.
+func newFSFeatures(flags uint32) FSFeatures {
+	return FSFeatures{
+		CaseSensitive:    flags&fileCaseSensitiveSearch != 0,
+		CasePreserved:    flags&fileCasePreservedNames != 0,
+		Unicode:          flags&fileUnicodeOnDisk != 0,
+		PersistentACLs:   flags&filePersistentACLs != 0,
+		Compression:      flags&fileFileCompression != 0,
+		Quotas:           flags&fileVolumeQuotas != 0,
+		SparseFiles:      flags&fileSupportsSparseFiles != 0,
+		ReparsePoints:    flags&fileSupportsReparsePoints != 0,
+		Compressed:       flags&fileVolumeIsCompressed != 0,
+		ObjectIDs:        flags&fileSupportsObjectIDs != 0,
+		Encryption:       flags&fileSupportsEncryption != 0,
+		ReadOnly:         flags&fileReadOnlyVolume != 0,
+		IntegrityStreams: flags&fileSupportsIntegrityStreams != 0,
+	}
+}
+
+// FileSystemFeatures reports the capabilities of the volume's file system, such as support for
+// compression or sparse files.
+//
+// Example:
+//		f, err := v.FileSystemFeatures()
+//		if err == nil && f.Compression {
+//			...
+//		}
+func (v *Volume) FileSystemFeatures() (FSFeatures, error) {
+	if err := v.svc.checkLocal(); err != nil {
+		return FSFeatures{}, fmt.Errorf("FileSystemFeatures: %w", err)
+	}
+	root := v.rootPath()
+	flags, err := volumeFlags(root)
+	if err != nil {
+		return FSFeatures{}, fmt.Errorf("FileSystemFeatures: %w", err)
+	}
+	return newFSFeatures(flags), nil
 }
 
 // Flush flushes the cached data in the volume's file system to disk.
 //
 // Ref: https://docs.microsoft.com/en-us/previous-versions/windows/desktop/stormgmt/msft-volume-flush
 func (v *Volume) Flush() error {
-	res, err := oleutil.CallMethod(v.handle, "Flush")
+	res, err := v.svc.wmi().CallMethod(v.handle, "Flush")
 	if err != nil {
 		return fmt.Errorf("Flush: %w", err)
 	} else if val, ok := res.Value().(int32); val != 0 || !ok {
-		return fmt.Errorf("error code returned during flush: %d", val)
+		return &StorageError{"flush", val}
 	}
 	return nil
 }
@@ -65,13 +375,26 @@ func (v *Volume) Flush() error {
 //
 // fs can be one of "ExFAT", "FAT", "FAT32", "NTFS", "ReFS"
 //
-// If successful, the formatted volume is returned as a new Volume object. Close() must be called on the new Volume.
+// compress applies to NTFS only, and marks the root directory of the new file system as compressed,
+// so that files and directories created on the volume are compressed. It does not compress anything
+// written before the flag took effect; EnableCompression has the same effect on a formatted volume.
+//
+// If successful, the formatted volume is returned as a new, fully populated Volume object, holding
+// its own handle to the same volume, so methods such as Query can be called on it. Close() must be
+// called on the new Volume; closing it does not affect v.
+//
+// Formatting does not change the volume GUID, so mount points and boot configuration referencing the
+// volume by GUID remain valid.
 //
 // Ref: https://docs.microsoft.com/en-us/previous-versions/windows/desktop/stormgmt/format-msft-volume
 func (v *Volume) Format(fs string, fsLabel string, allocationUnitSize int32,
 	full, force, compress, shortFileNameSupport, setIntegrityStreams, useLargeFRS, disableHeatGathering bool) (Volume, ExtendedStatus, error) {
-	vol := Volume{}
+	defer v.svc.timeCall("Volume.Format")()
+	vol := Volume{svc: v.svc}
 	stat := ExtendedStatus{}
+	if v.svc.skipDryRun("Volume.Format(%s, %s, %q)", v.Path, fs, fsLabel) {
+		return v.Clone(), stat, nil
+	}
 
 	var extendedStatus ole.VARIANT
 	ole.VariantInit(&extendedStatus)
@@ -101,163 +424,1824 @@ func (v *Volume) Format(fs string, fsLabel string, allocationUnitSize int32,
 		icompress = compress
 	}
 
-	res, err := oleutil.CallMethod(v.handle, "Format", fs, fsLabel, ialloc, full, force, icompress,
+	res, err := v.svc.wmi().CallMethod(v.handle, "Format", fs, fsLabel, ialloc, full, force, icompress,
 		ishortn, iintegrity, ilfrs, disableHeatGathering, &formattedVolume, &extendedStatus)
+	stat = v.svc.newExtendedStatus(&extendedStatus)
 	if err != nil {
 		return vol, stat, fmt.Errorf("Format: %w", err)
 	} else if val, ok := res.Value().(int32); val != 0 || !ok {
-		return vol, stat, fmt.Errorf("error code returned during formatting: %d", val)
+		return vol, stat, &StorageError{"formatting", val}
 	}
 
-	// TODO(mattl): figure out why this handle is invalid
-	vol.handle = formattedVolume.ToIDispatch()
+	// FormattedVolume is an embedded instance without an object path, so methods cannot be called
+	// on it. Return a new reference to this volume, refreshed to pick up the new file system, instead.
+	formattedVolume.Clear()
+	if err := v.svc.refreshObject(v.handle); err != nil {
+		return vol, stat, fmt.Errorf("Format: %w", err)
+	}
+	v.handle.AddRef()
+	vol.handle = v.handle
+	return vol, stat, vol.Query()
+}
 
-	return vol, stat, nil
+// FormatOptions holds the parameters used to format a volume. See Format for their meaning.
+type FormatOptions struct {
+	FileSystem           string
+	FileSystemLabel      string
+	AllocationUnitSize   int32
+	Full                 bool
+	Force                bool
+	Compress             bool
+	ShortFileNameSupport bool
+	SetIntegrityStreams  bool
+	UseLargeFRS          bool
+	DisableHeatGathering bool
 }
 
-// Optimize optimizes the volume.
+// FormatAsync formats a volume in the background.
 //
-// Ref: https://docs.microsoft.com/en-us/previous-versions/windows/desktop/stormgmt/optimize-msft-volume
-func (v *Volume) Optimize(reTrim, analyze, defrag, slabConslidate, tierOptimize bool) (ExtendedStatus, error) {
-	stat := ExtendedStatus{}
-	var extendedStatus ole.VARIANT
-	ole.VariantInit(&extendedStatus)
+// The first channel reports the percentage of the format completed, and is closed once formatting
+// finishes. The second channel receives the result of the format and is then closed. See FormatJob
+// for a handle which also supports deadlines and cancellation.
+//
+// The formatted volume handle is released; callers should re-query the volume when the format completes.
+//
+// Example:
+//		progress, errc := v.FormatAsync(storage.FormatOptions{FileSystem: "NTFS", Full: true})
+//		for pct := range progress {
+//			logger.Infof("format %d%% complete", pct)
+//		}
+//		if err := <-errc; err != nil {
+//			return err
+//		}
+func (v *Volume) FormatAsync(opts FormatOptions) (<-chan int, <-chan error) {
+	job := v.StartFormat(opts)
+	errc := make(chan error, 1)
+	v.svc.background(func() {
+		defer close(errc)
+		fv, _, err := job.Wait(context.Background())
+		if err == nil {
+			fv.Close()
+		}
+		errc <- err
+	})
+	return job.Progress(), errc
+}
+
+// FormatJob tracks a format running in the background. Use StartFormat to create one.
+type FormatJob struct {
+	progress chan int
+	done     chan struct{}
+
+	mu        sync.Mutex
+	finished  bool
+	cancelled bool
+
+	vol  Volume
+	stat ExtendedStatus
+	err  error
+}
+
+// StartFormat begins formatting the volume and returns without waiting for the format to complete.
+// The format runs on its own reference to the volume, so v may be closed while it is in progress, and
+// Service.Close waits for it to finish.
+//
+// Example:
+//		job := v.StartFormat(storage.FormatOptions{FileSystem: "NTFS", Full: true})
+//		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Hour)
+//		defer cancel()
+//		fv, _, err := job.Wait(ctx)
+//		if err != nil {
+//			job.Cancel()
+//			return err
+//		}
+//		defer fv.Close()
+func (v *Volume) StartFormat(opts FormatOptions) *FormatJob {
+	j := &FormatJob{
+		progress: make(chan int, 2),
+		done:     make(chan struct{}),
+	}
+	src := v.Clone()
+	v.svc.background(func() {
+		defer src.Close()
+		defer close(j.done)
+		defer close(j.progress)
+		j.progress <- 0
+		vol, stat, err := src.Format(opts.FileSystem, opts.FileSystemLabel, opts.AllocationUnitSize, opts.Full, opts.Force,
+			opts.Compress, opts.ShortFileNameSupport, opts.SetIntegrityStreams, opts.UseLargeFRS, opts.DisableHeatGathering)
+
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		j.finished = true
+		if j.cancelled {
+			if err == nil {
+				vol.Close()
+			}
+			j.err = ErrFormatCancelled
+			return
+		}
+		j.vol, j.stat, j.err = vol, stat, err
+		if err == nil {
+			j.progress <- 100
+		}
+	})
+	return j
+}
+
+// Progress returns a channel reporting the percentage of the format completed. The channel is closed
+// when the format finishes. The WMI provider does not report intermediate progress for Format, so only
+// 0 and 100 are sent.
+func (j *FormatJob) Progress() <-chan int {
+	return j.progress
+}
+
+// Wait blocks until the format completes or ctx is done, and returns the formatted volume.
+// If ctx is done first, its error is returned and the format continues in the background.
+func (j *FormatJob) Wait(ctx context.Context) (Volume, ExtendedStatus, error) {
+	select {
+	case <-j.done:
+		return j.vol, j.stat, j.err
+	case <-ctx.Done():
+		return Volume{}, ExtendedStatus{}, ctx.Err()
+	}
+}
+
+// Cancel abandons the format. A WMI Format call cannot be interrupted once issued, so the volume may
+// still be formatted; the resulting volume is released when the call returns, and Wait reports
+// ErrFormatCancelled. Cancel has no effect once the format has finished.
+func (j *FormatJob) Cancel() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if !j.finished {
+		j.cancelled = true
+	}
+}
+
+// FormatContext formats the volume like Format, but returns ctx.Err() if ctx is done before the format
+// completes. The WMI call cannot be interrupted, so the format continues in the background on its own
+// reference to the volume, and the resulting volume is released when it finishes. Service.Close waits
+// for it.
+//
+// Close() must be called on the resulting Volume.
+//
+// Example:
+//		ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+//		defer cancel()
+//		fv, _, err := v.FormatContext(ctx, storage.FormatOptions{FileSystem: "NTFS"})
+func (v *Volume) FormatContext(ctx context.Context, opts FormatOptions) (Volume, ExtendedStatus, error) {
+	job := v.StartFormat(opts)
+	vol, stat, err := job.Wait(ctx)
+	if err != nil && err == ctx.Err() {
+		job.Cancel()
+	}
+	return vol, stat, err
+}
+
+// DirUsage reports the space used by a directory tree.
+type DirUsage struct {
+	Path  string
+	Size  uint64
+	Files int
+}
 
-	res, err := oleutil.CallMethod(v.handle, "Optimize", reTrim, analyze, defrag, slabConslidate, tierOptimize, &extendedStatus)
+// GetLargestDirectories walks the volume and returns the n top-level directories using the most
+// space, largest first, to help find what is consuming a volume. Files and directories that cannot
+// be read are skipped, and mount points and other reparse points are not followed.
+//
+// Walking a large volume can take a long time.
+//
+// Example:
+//		dirs, err := v.GetLargestDirectories(5)
+//		for _, d := range dirs {
+//			logger.Infof("%s: %d bytes in %d files", d.Path, d.Size, d.Files)
+//		}
+func (v *Volume) GetLargestDirectories(n int) ([]DirUsage, error) {
+	if err := v.svc.checkLocal(); err != nil {
+		return nil, fmt.Errorf("GetLargestDirectories: %w", err)
+	}
+	root := v.rootPath()
+	if root == "" {
+		return nil, fmt.Errorf("GetLargestDirectories: volume has no accessible path")
+	}
+	dirs, err := largestDirectories(root, n)
 	if err != nil {
-		return stat, fmt.Errorf("Optimize: %w", err)
-	} else if val, ok := res.Value().(int32); val != 0 || !ok {
-		return stat, fmt.Errorf("error code returned during optimization: %d", val)
+		return nil, fmt.Errorf("GetLargestDirectories: %w", err)
 	}
-	return stat, nil
+	return dirs, nil
 }
 
-// SetFileSystemLabel Sets the file system label for the volume.
+// largestDirectories returns the n directories directly under root using the most space.
+func largestDirectories(root string, n int) ([]DirUsage, error) {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return nil, err
+	}
+	var dirs []DirUsage
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		usage := DirUsage{Path: filepath.Join(root, e.Name())}
+		filepath.Walk(usage.Path, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				// Skip unreadable entries rather than abandoning the walk.
+				if info != nil && info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if info.Mode().IsRegular() {
+				usage.Size += uint64(info.Size())
+				usage.Files++
+			}
+			return nil
+		})
+		dirs = append(dirs, usage)
+	}
+	sort.Slice(dirs, func(i, j int) bool { return dirs[i].Size > dirs[j].Size })
+	if n >= 0 && len(dirs) > n {
+		dirs = dirs[:n]
+	}
+	return dirs, nil
+}
+
+// GetSupportedFileSystems returns the names of the file systems the volume can be formatted with,
+// which depends on the volume and on the Windows edition.
 //
-// Ref: https://docs.microsoft.com/en-us/previous-versions/windows/desktop/stormgmt/msft-volume-setfilesystemlabel
-func (v *Volume) SetFileSystemLabel(fileSystemLabel string) (ExtendedStatus, error) {
-	stat := ExtendedStatus{}
+// Ref: https://docs.microsoft.com/en-us/previous-versions/windows/desktop/stormgmt/msft-volume-getsupportedfilesystems
+func (v *Volume) GetSupportedFileSystems() ([]string, error) {
+	var supported ole.VARIANT
+	ole.VariantInit(&supported)
 	var extendedStatus ole.VARIANT
 	ole.VariantInit(&extendedStatus)
-
-	res, err := oleutil.CallMethod(v.handle, "SetFileSystemLabel", fileSystemLabel, &extendedStatus)
+	defer ole.VariantClear(&supported)
+	defer ole.VariantClear(&extendedStatus)
+	res, err := v.svc.wmi().CallMethod(v.handle, "GetSupportedFileSystems", &supported, &extendedStatus)
 	if err != nil {
-		return stat, fmt.Errorf("SetFileSystemLabel: %w", err)
+		return nil, fmt.Errorf("GetSupportedFileSystems: %w", err)
 	} else if val, ok := res.Value().(int32); val != 0 || !ok {
-		return stat, fmt.Errorf("error code returned during setting file system label: %d", val)
+		return nil, &StorageError{"GetSupportedFileSystems", val}
 	}
-	return stat, nil
+
+	var names []string
+	if arr := supported.ToArray(); arr != nil {
+		for _, fs := range arr.ToValueArray() {
+			switch t := fs.(type) {
+			case string:
+				names = append(names, t)
+			case uint16:
+				names = append(names, enumName(fileSystemTypeNames, int32(t)))
+			case int32:
+				names = append(names, enumName(fileSystemTypeNames, t))
+			}
+		}
+	}
+	return names, nil
 }
 
-// Query reads and populates the volume state.
-func (v *Volume) Query() error {
-	if v.handle == nil {
-		return fmt.Errorf("invalid handle")
+// GetSupportedSize returns the minimum and maximum sizes, in bytes, the volume can be resized to.
+// The minimum is bounded by the data stored on the volume, and the maximum by the free space
+// following its partition.
+func (v *Volume) GetSupportedSize() (uint64, uint64, error) {
+	part, err := v.partition()
+	if err != nil {
+		return 0, 0, fmt.Errorf("GetSupportedSize: %w", err)
+	}
+	defer part.Close()
+	return part.GetSupportedSize()
+}
+
+// Dismount dismounts the volume's file system. Cached data is flushed first.
+//
+// If the volume has open handles, Dismount fails with ErrVolumeInUse, unless force is set, in which
+// case the volume is dismounted regardless and the open handles are invalidated.
+//
+// Without permanent, the volume is mounted again automatically on next access. With permanent, the
+// partition backing the volume is also taken offline, and the volume stays dismounted until Mount is
+// called on the same Volume. The system and boot volumes cannot be dismounted permanently, and
+// ErrSystemPartition is returned for them.
+//
+// Example:
+//		if err := v.Dismount(false, true); errors.Is(err, storage.ErrVolumeInUse) {
+//			// close open files, or retry with force
+//		}
+func (v *Volume) Dismount(force, permanent bool) error {
+	if err := v.svc.checkLocal(); err != nil {
+		return fmt.Errorf("Dismount: %w", err)
+	}
+	if v.svc.skipDryRun("Volume.Dismount(%s, force=%t, permanent=%t)", v.Path, force, permanent) {
+		return nil
+	}
+	if err := v.Flush(); err != nil {
+		return fmt.Errorf("Dismount: %w", err)
+	}
+	// Look up the partition first, as it cannot be found by the volume once dismounted.
+	var part Partition
+	if permanent {
+		var err error
+		if part, err = v.partition(); err != nil {
+			return fmt.Errorf("Dismount: %w", err)
+		}
+		defer part.Close()
+		if part.IsSystem || part.IsBoot {
+			return fmt.Errorf("Dismount: partition %d on disk %d: %w", part.PartitionNumber, part.DiskNumber, ErrSystemPartition)
+		}
 	}
+	if err := dismountVolume(v.Path, force); err != nil {
+		return fmt.Errorf("Dismount: %w", err)
+	}
+	if permanent {
+		path, err := v.svc.objectPath(part.handle)
+		if err != nil {
+			return fmt.Errorf("Dismount: %w", err)
+		}
+		if _, err := part.Offline(); err != nil {
+			return fmt.Errorf("Dismount: %w", err)
+		}
+		v.offlinePartition = path
+	}
+	return nil
+}
 
-	// DriveLetter
-	p, err := oleutil.GetProperty(v.handle, "DriveLetter")
+// Mount mounts the volume again after Dismount, bringing the partition backing it online if it was
+// dismounted permanently, and refreshes the volume state.
+//
+// Example:
+//		v.Mount()
+func (v *Volume) Mount() error {
+	part, err := v.mountPartition()
 	if err != nil {
-		return fmt.Errorf("oleutil.GetProperty(DriveLetter): %w", err)
+		return fmt.Errorf("Mount: %w", err)
 	}
-	// DriveLetter is represented as Char16 (Ascii)
-	v.DriveLetter = string(rune(p.Val))
+	defer part.Close()
+	if part.IsOffline {
+		if _, err := part.Online(); err != nil {
+			return fmt.Errorf("Mount: %w", err)
+		}
+	}
+	v.offlinePartition = ""
+	if err := v.svc.refreshObject(v.handle); err != nil {
+		return fmt.Errorf("Mount: %w", err)
+	}
+	return v.Query()
+}
 
-	// Path
-	p, err = oleutil.GetProperty(v.handle, "Path")
+// mountPartition returns the partition backing the volume. The partition taken offline by a permanent
+// Dismount is no longer associated with the volume, so it is opened by the path recorded at the time.
+func (v *Volume) mountPartition() (Partition, error) {
+	if v.offlinePartition == "" {
+		return v.partition()
+	}
+	h, err := v.svc.getObject(v.offlinePartition)
 	if err != nil {
-		return fmt.Errorf("oleutil.GetProperty(Path): %w", err)
+		return Partition{}, err
+	}
+	part := Partition{handle: h, svc: v.svc}
+	if err := part.Query(); err != nil {
+		part.Close()
+		return Partition{}, err
 	}
-	v.Path = p.ToString()
+	return part, nil
+}
 
-	// FileSystem
-	p, err = oleutil.GetProperty(v.handle, "FileSystem")
+// IsDirty reports whether the volume's dirty bit is set, indicating that the file system may be
+// inconsistent and chkdsk will run on next boot.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/api/winioctl/ni-winioctl-fsctl_is_volume_dirty
+func (v *Volume) IsDirty() (bool, error) {
+	if err := v.svc.checkLocal(); err != nil {
+		return false, fmt.Errorf("IsDirty: %w", err)
+	}
+	dirty, err := volumeDirty(v.Path)
 	if err != nil {
-		return fmt.Errorf("oleutil.GetProperty(FileSystem): %w", err)
+		return false, fmt.Errorf("IsDirty: %w", err)
+	}
+	return dirty, nil
+}
+
+// MountAt mounts the volume at folderPath, which must be an existing empty folder on an NTFS volume.
+// The mount point is added as an access path of the partition backing the volume.
+//
+// Example:
+//		v.MountAt(`C:\mnt\data`)
+func (v *Volume) MountAt(folderPath string) error {
+	if err := v.svc.checkLocal(); err != nil {
+		return fmt.Errorf("MountAt(%s): %w", folderPath, err)
+	}
+	if err := checkMountFolder(folderPath); err != nil {
+		return fmt.Errorf("MountAt(%s): %w", folderPath, err)
 	}
-	v.FileSystem = p.ToString()
 
-	// FileSystemLabel
-	p, err = oleutil.GetProperty(v.handle, "FileSystemLabel")
+	part, err := v.partition()
 	if err != nil {
-		return fmt.Errorf("oleutil.GetProperty(FileSystemLabel): %w", err)
+		return fmt.Errorf("MountAt(%s): %w", folderPath, err)
 	}
-	v.FileSystemLabel = p.ToString()
+	defer part.Close()
 
-	// All the non-strings
-	for _, p := range [][]interface{}{
-		[]interface{}{"HealthStatus", &v.HealthStatus},
-		[]interface{}{"FileSystemType", &v.FileSystemType},
-		[]interface{}{"Size", &v.Size},
-		[]interface{}{"SizeRemaining", &v.SizeRemaining},
-		[]interface{}{"DriveType", &v.DriveType},
-		[]interface{}{"DedupMode", &v.DedupMode},
-	} {
-		prop, err := oleutil.GetProperty(v.handle, p[0].(string))
-		if err != nil {
-			return fmt.Errorf("oleutil.GetProperty(%s): %w", p[0].(string), err)
-		}
-		if err := assignVariant(prop.Value(), p[1]); err != nil {
-			logger.Warningf("assignVariant(%s): %v", p[0].(string), err)
+	if _, err := part.AddAccessPath(mountPointPath(folderPath), false); err != nil {
+		return fmt.Errorf("MountAt(%s): %w", folderPath, err)
+	}
+	return nil
+}
+
+// checkMountFolder returns an error unless path is an existing, empty folder.
+func checkMountFolder(path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("not a folder")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Readdirnames(1); err != io.EOF {
+		if err == nil {
+			return fmt.Errorf("folder is not empty")
 		}
+		return err
 	}
 	return nil
 }
 
-// A VolumeSet contains one or more Volumes.
-type VolumeSet struct {
-	Volumes []Volume
+// mountPointPath returns path in the form expected for mount point access paths, which must end
+// with a backslash.
+func mountPointPath(path string) string {
+	if !strings.HasSuffix(path, `\`) {
+		return path + `\`
+	}
+	return path
 }
 
-// Close releases all Volume handles inside a VolumeSet.
-func (s *VolumeSet) Close() {
-	for _, v := range s.Volumes {
-		v.Close()
+// ObjectPath returns the WMI object path (__PATH) of the volume, which uniquely identifies the
+// instance and can be used to retrieve it again.
+func (v *Volume) ObjectPath() string {
+	return v.objectPath
+}
+
+// Optimize optimizes the volume.
+//
+// Ref: https://docs.microsoft.com/en-us/previous-versions/windows/desktop/stormgmt/optimize-msft-volume
+func (v *Volume) Optimize(reTrim, analyze, defrag, slabConslidate, tierOptimize bool) (ExtendedStatus, error) {
+	defer v.svc.timeCall("Volume.Optimize")()
+	stat := ExtendedStatus{}
+	var extendedStatus ole.VARIANT
+	ole.VariantInit(&extendedStatus)
+
+	res, err := v.svc.wmi().CallMethod(v.handle, "Optimize", reTrim, analyze, defrag, slabConslidate, tierOptimize, &extendedStatus)
+	stat = v.svc.newExtendedStatus(&extendedStatus)
+	if err != nil {
+		return stat, fmt.Errorf("Optimize: %w", err)
+	} else if val, ok := res.Value().(int32); val != 0 || !ok {
+		return stat, &StorageError{"optimization", val}
 	}
+	return stat, nil
 }
 
-// GetVolumes queries for local volumes.
+// OfflineRepair dismounts the volume, runs an offline scan and fix of the file system, and
+// refreshes the volume state once it has been mounted again.
 //
-// Close() must be called on the resulting VolumeSet to ensure all volumes are released.
+// If the volume has open handles, OfflineRepair fails with ErrVolumeInUse, unless force is set, in
+// which case the volume is dismounted regardless and the open handles are invalidated.
 //
-// Get all volumes:
-//		svc.GetVolumes("")
+// The result reports the outcome of the repair, as for Repair.
 //
-// To get specific volumes, provide a valid WMI query filter string, for example:
-//		svc.GetVolumes("WHERE DriveLetter=D")
-func (svc Service) GetVolumes(filter string) (VolumeSet, error) {
-	vset := VolumeSet{}
-	query := "SELECT * FROM MSFT_Volume"
-	if filter != "" {
-		query = fmt.Sprintf("%s %s", query, filter)
+// Ref: https://docs.microsoft.com/en-us/previous-versions/windows/desktop/stormgmt/msft-volume-repair
+func (v *Volume) OfflineRepair(force bool) (RepairResult, ExtendedStatus, error) {
+	if err := v.svc.checkLocal(); err != nil {
+		return 0, ExtendedStatus{}, fmt.Errorf("OfflineRepair: %w", err)
 	}
-	raw, err := oleutil.CallMethod(svc.wmiSvc, "ExecQuery", query)
-	if err != nil {
-		return vset, fmt.Errorf("ExecQuery(%s): %w", query, err)
+	if v.svc.skipDryRun("Volume.OfflineRepair(%s, force=%t)", v.Path, force) {
+		return RepairNoErrors, ExtendedStatus{}, nil
 	}
-	result := raw.ToIDispatch()
-	defer result.Release()
-
-	countVar, err := oleutil.GetProperty(result, "Count")
+	if err := v.Flush(); err != nil {
+		return 0, ExtendedStatus{}, fmt.Errorf("OfflineRepair: %w", err)
+	}
+	if err := dismountVolume(v.Path, force); err != nil {
+		return 0, ExtendedStatus{}, fmt.Errorf("OfflineRepair: %w", err)
+	}
+	out, stat, err := v.repair(true, false, false)
 	if err != nil {
-		return vset, fmt.Errorf("oleutil.GetProperty(Count): %w", err)
+		return RepairResult(out), stat, fmt.Errorf("OfflineRepair: %w", err)
 	}
-	count := int(countVar.Val)
+	// Accessing the volume mounts it again.
+	return RepairResult(out), stat, v.Query()
+}
 
-	for i := 0; i < count; i++ {
-		v := Volume{}
-		itemRaw, err := oleutil.CallMethod(result, "ItemIndex", i)
-		if err != nil {
-			return vset, fmt.Errorf("oleutil.CallMethod(ItemIndex, %d): %w", i, err)
-		}
-		v.handle = itemRaw.ToIDispatch()
+// OptimizeContext optimizes the volume like Optimize, but returns ctx.Err() if ctx is done before the
+// optimization completes. The WMI call cannot be interrupted: the optimization continues in the
+// background on its own reference to the volume, so v may be closed once OptimizeContext returns, and
+// Service.Close waits for it to finish.
+func (v *Volume) OptimizeContext(ctx context.Context, opts OptimizeOptions) (ExtendedStatus, error) {
+	type optimizeResult struct {
+		stat ExtendedStatus
+		err  error
+	}
+	c := make(chan optimizeResult, 1)
+	vol := v.Clone()
+	v.svc.background(func() {
+		defer vol.Close()
+		stat, err := vol.Optimize(opts.ReTrim, opts.Analyze, opts.Defrag, opts.SlabConsolidate, opts.TierOptimize)
+		c <- optimizeResult{stat, err}
+	})
+	select {
+	case r := <-c:
+		return r.stat, r.err
+	case <-ctx.Done():
+		return ExtendedStatus{}, ctx.Err()
+	}
+}
 
-		if err := v.Query(); err != nil {
-			return vset, err
-		}
+// OptimizeOptions selects the operations performed by Optimize.
+type OptimizeOptions struct {
+	ReTrim          bool
+	Analyze         bool
+	Defrag          bool
+	SlabConsolidate bool
+	TierOptimize    bool
+}
 
-		vset.Volumes = append(vset.Volumes, v)
+// ScheduleCheckDisk sets the volume's dirty bit so that chkdsk checks and repairs the volume on
+// next boot. The dirty bit is cleared by chkdsk once the volume has been checked.
+//
+// Ref: https://docs.microsoft.com/en-us/windows-server/administration/windows-commands/fsutil-dirty
+func (v *Volume) ScheduleCheckDisk() error {
+	if err := v.svc.checkLocal(); err != nil {
+		return fmt.Errorf("ScheduleCheckDisk: %w", err)
 	}
-
-	return vset, nil
+	args := []string{"dirty", "set", v.DevicePath()}
+	if v.DriveLetter != "" {
+		args[2] = v.DriveLetter + ":"
+	}
+	if _, err := fnExec(fsutilExe, args, nil); err != nil {
+		return fmt.Errorf("ScheduleCheckDisk: %w", err)
+	}
+	return nil
+}
+
+// SetFileSystemLabel Sets the file system label for the volume, and updates FileSystemLabel on success.
+//
+// Ref: https://docs.microsoft.com/en-us/previous-versions/windows/desktop/stormgmt/msft-volume-setfilesystemlabel
+func (v *Volume) SetFileSystemLabel(fileSystemLabel string) (ExtendedStatus, error) {
+	defer v.svc.timeCall("Volume.SetFileSystemLabel")()
+	stat := ExtendedStatus{}
+	var extendedStatus ole.VARIANT
+	ole.VariantInit(&extendedStatus)
+
+	res, err := v.svc.wmi().CallMethod(v.handle, "SetFileSystemLabel", fileSystemLabel, &extendedStatus)
+	stat = v.svc.newExtendedStatus(&extendedStatus)
+	if err != nil {
+		return stat, fmt.Errorf("SetFileSystemLabel: %w", err)
+	} else if val, ok := res.Value().(int32); val != 0 || !ok {
+		return stat, &StorageError{"setting file system label", val}
+	}
+	v.FileSystemLabel = fileSystemLabel
+	return stat, nil
+}
+
+// SetIntegrityStreams enables or disables integrity streams on a ReFS volume after it has been formatted.
+//
+// The setting is applied to the root directory of the volume, and is inherited by files and directories
+// created afterwards. Existing files keep their current setting.
+//
+// Ref: https://docs.microsoft.com/en-us/windows-server/storage/refs/integrity-streams
+func (v *Volume) SetIntegrityStreams(enable bool) error {
+	if err := v.svc.checkLocal(); err != nil {
+		return fmt.Errorf("SetIntegrityStreams: %w", err)
+	}
+	if !strings.EqualFold("ReFS", v.FileSystem) {
+		return fmt.Errorf("SetIntegrityStreams: integrity streams require ReFS, volume is %q", v.FileSystem)
+	}
+	if err := setIntegrity(v.Path, enable); err != nil {
+		return fmt.Errorf("SetIntegrityStreams(%t): %w", enable, err)
+	}
+	return nil
+}
+
+// SetSparse sets or clears the sparse attribute of a file on the volume. name is relative to the
+// root of the volume. It returns ErrNotSupported if the file system does not support sparse files.
+//
+// Neither NTFS nor ReFS have a volume-wide default for sparse allocation; the attribute is set per
+// file, typically on large, mostly empty files such as VM disk images.
+//
+// Example:
+//		v.SetSparse(`VMs\disk0.vhdx`, true)
+func (v *Volume) SetSparse(name string, sparse bool) error {
+	features, err := v.FileSystemFeatures()
+	if err != nil {
+		return fmt.Errorf("SetSparse(%s): %w", name, err)
+	}
+	if !features.SparseFiles {
+		return fmt.Errorf("SetSparse(%s): %s: %w", name, v.FileSystem, ErrNotSupported)
+	}
+	if err := setSparse(v.rootPath()+strings.TrimPrefix(name, `\`), sparse); err != nil {
+		return fmt.Errorf("SetSparse(%s, %t): %w", name, sparse, err)
+	}
+	return nil
+}
+
+// volumeProperties lists the MSFT_Volume properties read by Query.
+var volumeProperties = []string{
+	"DriveLetter", "Path", "FileSystem", "FileSystemLabel",
+	"HealthStatus", "FileSystemType", "Size", "SizeRemaining", "DriveType", "DedupMode",
+}
+
+// SupportedOptimizations reports which Optimize operations apply to the volume:
+//
+// Analyze and Defrag require an NTFS or FAT file system. ReTrim requires a device supporting TRIM or
+// a thinly provisioned disk. SlabConsolidate requires a thinly provisioned Storage Spaces disk, and
+// TierOptimize a Storage Spaces disk.
+//
+// Example:
+//		opts, err := v.SupportedOptimizations()
+//		v.Optimize(opts.ReTrim, false, opts.Defrag, opts.SlabConsolidate, opts.TierOptimize)
+func (v *Volume) SupportedOptimizations() (OptimizeOptions, error) {
+	if err := v.svc.checkLocal(); err != nil {
+		return OptimizeOptions{}, fmt.Errorf("SupportedOptimizations: %w", err)
+	}
+	opts := OptimizeOptions{}
+	switch strings.ToUpper(v.FileSystem) {
+	case "NTFS", "FAT", "FAT32":
+		opts.Analyze = true
+		opts.Defrag = true
+	}
+
+	part, err := v.partition()
+	if err != nil {
+		return opts, fmt.Errorf("SupportedOptimizations: %w", err)
+	}
+	d, err := part.disk()
+	part.Close()
+	if err != nil {
+		return opts, fmt.Errorf("SupportedOptimizations: %w", err)
+	}
+	defer d.Close()
+	thin := ProvisioningType(d.ProvisioningType) == ProvisioningThin
+	spaces := d.Bus() == BusTypeSpaces
+	opts.SlabConsolidate = spaces && thin
+	opts.TierOptimize = spaces
+
+	trim, err := trimEnabled(v.Path)
+	if err != nil {
+		return opts, fmt.Errorf("SupportedOptimizations: %w", err)
+	}
+	opts.ReTrim = trim || thin
+	return opts, nil
+}
+
+// IsSystem reports whether the volume is the system volume, holding the files needed to start
+// Windows, such as the EFI system partition. MSFT_Volume does not expose this, so it is read from
+// the partition hosting the volume; the result is cached on the Volume after the first call.
+//
+// Example:
+//		if sys, err := v.IsSystem(); err != nil || sys {
+//			return fmt.Errorf("refusing to clean up %s", v.Path)
+//		}
+func (v *Volume) IsSystem() (bool, error) {
+	if err := v.resolveProtection(); err != nil {
+		return false, fmt.Errorf("IsSystem: %w", err)
+	}
+	return v.isSystem, nil
+}
+
+// IsBoot reports whether the volume is the boot volume, holding the running Windows installation.
+// Like IsSystem, it is read from the partition hosting the volume, and cached after the first call.
+func (v *Volume) IsBoot() (bool, error) {
+	if err := v.resolveProtection(); err != nil {
+		return false, fmt.Errorf("IsBoot: %w", err)
+	}
+	return v.isBoot, nil
+}
+
+// resolveProtection reads IsSystem and IsBoot from the partition hosting the volume, unless they
+// were already read.
+func (v *Volume) resolveProtection() error {
+	if v.protectionKnown {
+		return nil
+	}
+	part, err := v.partition()
+	if err != nil {
+		return err
+	}
+	defer part.Close()
+	v.isSystem, v.isBoot, v.protectionKnown = part.IsSystem, part.IsBoot, true
+	return nil
+}
+
+// GetPartition returns the partition hosting the volume, found through the MSFT_PartitionToVolume
+// association, for example to change its access paths.
+//
+// Close() must be called on the resulting Partition.
+//
+// Example:
+//		part, err := v.GetPartition()
+//		if err != nil {
+//			return err
+//		}
+//		defer part.Close()
+//		part.AddAccessPath("E:", false)
+func (v *Volume) GetPartition() (Partition, error) {
+	part, err := v.partition()
+	if err != nil {
+		return part, fmt.Errorf("GetPartition(%s): %w", v.Path, err)
+	}
+	return part, nil
+}
+
+// partition returns the partition backing the volume, or an error wrapping ErrNotFound if there is
+// none, such as for the volume of an optical drive.
+//
+// Close() must be called on the resulting Partition.
+func (v *Volume) partition() (Partition, error) {
+	part := Partition{svc: v.svc}
+	h, err := v.svc.associator(v.handle, "MSFT_PartitionToVolume", "MSFT_Partition")
+	if err != nil {
+		return part, err
+	}
+	part.handle = h
+	if err := part.Query(); err != nil {
+		part.Close()
+		return Partition{svc: v.svc}, err
+	}
+	return part, nil
+}
+
+// reformatTimeout bounds how long Reformat waits for the formatted volume to reappear.
+var reformatTimeout = 30 * time.Second
+
+// Reformat formats the volume with opts while preserving its metadata: if opts.FileSystemLabel is
+// empty, the existing label is kept, and the drive letter and mount points of the volume are
+// restored if formatting removed them.
+//
+// Close() must be called on the resulting Volume.
+//
+// Example: change the cluster size of a data volume
+//		nv, err := v.Reformat(storage.FormatOptions{FileSystem: "NTFS", AllocationUnitSize: 65536})
+func (v *Volume) Reformat(opts FormatOptions) (Volume, error) {
+	part, err := v.partition()
+	if err != nil {
+		return Volume{}, fmt.Errorf("Reformat: %w", err)
+	}
+	defer part.Close()
+	paths, err := part.accessPaths()
+	if err != nil {
+		return Volume{}, fmt.Errorf("Reformat: %w", err)
+	}
+	if opts.FileSystemLabel == "" {
+		opts.FileSystemLabel = v.FileSystemLabel
+	}
+
+	fv, _, err := v.Format(opts.FileSystem, opts.FileSystemLabel, opts.AllocationUnitSize, opts.Full, opts.Force,
+		opts.Compress, opts.ShortFileNameSupport, opts.SetIntegrityStreams, opts.UseLargeFRS, opts.DisableHeatGathering)
+	if err != nil {
+		return Volume{}, fmt.Errorf("Reformat: %w", err)
+	}
+	fv.Close()
+
+	if err := v.svc.refreshObject(part.handle); err != nil {
+		return Volume{}, fmt.Errorf("Reformat: %w", err)
+	}
+	current, err := part.accessPaths()
+	if err != nil {
+		return Volume{}, fmt.Errorf("Reformat: %w", err)
+	}
+	present := make(map[string]bool)
+	for _, p := range current {
+		present[strings.ToLower(p)] = true
+	}
+	for _, p := range paths {
+		// The volume GUID path is not an assignable access path.
+		if present[strings.ToLower(p)] || strings.HasPrefix(p, `\\?\`) {
+			continue
+		}
+		if _, err := part.AddAccessPath(p, false); err != nil {
+			return Volume{}, fmt.Errorf("Reformat: restoring access path %s: %w", p, err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), reformatTimeout)
+	defer cancel()
+	nv, err := part.WaitForVolume(ctx)
+	if err != nil {
+		return nv, fmt.Errorf("Reformat: %w", err)
+	}
+	return nv, nil
+}
+
+// RemountAt moves the volume's mount point from oldPath to newPath, which must be an existing empty
+// folder on an NTFS volume. The new mount point is added before the old one is removed; if removing
+// the old mount point fails, the new one is removed again, leaving the volume mounted at oldPath.
+//
+// Example:
+//		v.RemountAt(`C:\mnt\data`, `C:\data`)
+func (v *Volume) RemountAt(oldPath, newPath string) error {
+	if err := v.svc.checkLocal(); err != nil {
+		return fmt.Errorf("RemountAt(%s, %s): %w", oldPath, newPath, err)
+	}
+	if err := checkMountFolder(newPath); err != nil {
+		return fmt.Errorf("RemountAt(%s, %s): %w", oldPath, newPath, err)
+	}
+
+	part, err := v.partition()
+	if err != nil {
+		return fmt.Errorf("RemountAt(%s, %s): %w", oldPath, newPath, err)
+	}
+	defer part.Close()
+
+	newMount := mountPointPath(newPath)
+	if _, err := part.AddAccessPath(newMount, false); err != nil {
+		return fmt.Errorf("RemountAt(%s, %s): %w", oldPath, newPath, err)
+	}
+	if _, err := part.RemoveAccessPath(mountPointPath(oldPath)); err != nil {
+		if _, rerr := part.RemoveAccessPath(newMount); rerr != nil {
+			return fmt.Errorf("RemountAt(%s, %s): %v; rollback failed, volume is mounted at both paths: %w", oldPath, newPath, err, rerr)
+		}
+		return fmt.Errorf("RemountAt(%s, %s): %w", oldPath, newPath, err)
+	}
+	return nil
+}
+
+// Resize shrinks or grows the volume, and its partition, to size bytes. An error is returned without
+// attempting the resize if size is outside the range reported by GetSupportedSize.
+//
+// The volume is re-queried on success, so Size reflects the new size.
+//
+// Example:
+//		min, max, err := v.GetSupportedSize()
+//		v.Resize(max)
+func (v *Volume) Resize(size uint64) (ExtendedStatus, error) {
+	stat := ExtendedStatus{}
+	if v.svc.skipDryRun("Volume.Resize(%s, %d)", v.Path, size) {
+		return stat, nil
+	}
+	part, err := v.partition()
+	if err != nil {
+		return stat, fmt.Errorf("Resize: %w", err)
+	}
+	defer part.Close()
+
+	// Partition.Resize checks size against GetSupportedSize.
+	if stat, err = part.Resize(size); err != nil {
+		return stat, err
+	}
+	// The handle is a snapshot taken before the resize; re-read it so Size is current.
+	if err := v.svc.refreshObject(v.handle); err != nil {
+		return stat, err
+	}
+	return stat, v.Query()
+}
+
+// RepairResult is the outcome of a volume scan or repair, as reported in the Output parameter of
+// MSFT_Volume.Repair.
+type RepairResult uint32
+
+const (
+	// RepairNoErrors indicates no file system errors were found.
+	RepairNoErrors RepairResult = 0
+	// RepairErrorsFixed indicates errors were found and fixed.
+	RepairErrorsFixed RepairResult = 1
+	// RepairNeedsSpotFix indicates errors were found which can be fixed online with a spot fix.
+	RepairNeedsSpotFix RepairResult = 2
+	// RepairNeedsOfflineScan indicates errors were found which require an offline scan and fix.
+	RepairNeedsOfflineScan RepairResult = 3
+)
+
+// String returns a description of the repair result.
+func (r RepairResult) String() string {
+	switch r {
+	case RepairNoErrors:
+		return "NoErrorsFound"
+	case RepairErrorsFixed:
+		return "ErrorsFixed"
+	case RepairNeedsSpotFix:
+		return "SpotFixNeeded"
+	case RepairNeedsOfflineScan:
+		return "OfflineScanAndFixNeeded"
+	}
+	return fmt.Sprintf("Unknown(%d)", uint32(r))
+}
+
+// NeedsRepair reports whether the result indicates errors remain to be fixed.
+func (r RepairResult) NeedsRepair() bool {
+	return r == RepairNeedsSpotFix || r == RepairNeedsOfflineScan
+}
+
+// Repair repairs the file system of the volume. Errors found by a previous Scan are fixed online
+// with a spot fix, or, if offlineScanAndFix is set, the volume is taken offline and fully scanned
+// and fixed. Open handles to the volume are invalidated by an offline repair; see OfflineRepair
+// to refuse a repair while the volume is in use.
+//
+// The result is the outcome reported by the provider, so that a spot fix which leaves the volume
+// needing an offline scan is reported as RepairNeedsOfflineScan rather than as an error.
+//
+// Example:
+//		res, _, err := v.Scan()
+//		if err == nil && res.NeedsRepair() {
+//			v.Repair(res == storage.RepairNeedsOfflineScan)
+//		}
+//
+// Ref: https://docs.microsoft.com/en-us/previous-versions/windows/desktop/stormgmt/msft-volume-repair
+func (v *Volume) Repair(offlineScanAndFix bool) (RepairResult, ExtendedStatus, error) {
+	if v.svc.skipDryRun("Volume.Repair(%s, offlineScanAndFix=%t)", v.Path, offlineScanAndFix) {
+		return RepairNoErrors, ExtendedStatus{}, nil
+	}
+	out, stat, err := v.repair(offlineScanAndFix, false, !offlineScanAndFix)
+	if err != nil {
+		return RepairResult(out), stat, err
+	}
+	return RepairResult(out), stat, v.Query()
+}
+
+// Scan scans the file system of the volume online, without fixing it. Errors found are reported
+// through the returned result rather than as an error, which is reserved for failures to scan.
+//
+// MSFT_Volume has no Scan method and no scan modes: Scan calls Repair with only its Scan flag set.
+//
+// Ref: https://docs.microsoft.com/en-us/previous-versions/windows/desktop/stormgmt/msft-volume-repair
+func (v *Volume) Scan() (RepairResult, ExtendedStatus, error) {
+	out, stat, err := v.repair(false, true, false)
+	return RepairResult(out), stat, err
+}
+
+// repair calls MSFT_Volume.Repair with the given modes, and returns its Output code.
+func (v *Volume) repair(offlineScanAndFix, scan, spotFix bool) (uint32, ExtendedStatus, error) {
+	defer v.svc.timeCall("Volume.Repair")()
+	stat := ExtendedStatus{}
+	var output ole.VARIANT
+	ole.VariantInit(&output)
+	var extendedStatus ole.VARIANT
+	ole.VariantInit(&extendedStatus)
+
+	res, err := v.svc.wmi().CallMethod(v.handle, "Repair", offlineScanAndFix, scan, spotFix, &output, &extendedStatus)
+	stat = v.svc.newExtendedStatus(&extendedStatus)
+	if err != nil {
+		return 0, stat, fmt.Errorf("Repair: %w", err)
+	} else if val, ok := res.Value().(int32); val != 0 || !ok {
+		return 0, stat, &StorageError{"repair", val}
+	}
+	out, _ := output.Value().(int32)
+	return uint32(out), stat, nil
+}
+
+// Query reads and populates the volume state.
+func (v *Volume) Query() error {
+	return v.QueryProperties()
+}
+
+// QueryProperties reads and populates only the named volume properties, saving COM round trips
+// when just a few fields are needed. If no properties are given, all properties are read, as with Query.
+//
+// Properties whose values cannot be converted are left at their zero values, and the remaining
+// properties are still read; the returned error then names those properties, and wraps ErrPartialQuery.
+//
+// Example: refresh the free space of a volume
+//		v.QueryProperties("Size", "SizeRemaining")
+func (v *Volume) QueryProperties(props ...string) error {
+	defer v.svc.timeCall("Volume.Query")()
+	if v.handle == nil {
+		return fmt.Errorf("invalid handle")
+	}
+
+	all := len(props) == 0
+	if all {
+		props = volumeProperties
+	}
+	var failed []string
+	for _, name := range props {
+		if err := v.queryProperty(name); err == errPropertyConversion {
+			failed = append(failed, name)
+		} else if err != nil {
+			return err
+		}
+	}
+
+	if all {
+		var err error
+		if v.objectPath, err = v.svc.objectPath(v.handle); err != nil {
+			return err
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("QueryProperties(%s): %w", strings.Join(failed, ", "), ErrPartialQuery)
+	}
+	return nil
+}
+
+// errPropertyConversion is returned by queryProperty if the property was read, but its value could
+// not be converted to the type of its field.
+var errPropertyConversion = errors.New("property conversion failed")
+
+// queryProperty reads a single volume property into its field.
+func (v *Volume) queryProperty(name string) error {
+	var dest interface{}
+	switch name {
+	case "DriveLetter":
+	case "Path":
+		dest = &v.Path
+	case "FileSystem":
+		dest = &v.FileSystem
+	case "FileSystemLabel":
+		dest = &v.FileSystemLabel
+	case "HealthStatus":
+		dest = &v.HealthStatus
+	case "FileSystemType":
+		dest = &v.FileSystemType
+	case "Size":
+		dest = &v.Size
+	case "SizeRemaining":
+		dest = &v.SizeRemaining
+	case "DriveType":
+		dest = &v.DriveType
+	case "DedupMode":
+		dest = &v.DedupMode
+	default:
+		return fmt.Errorf("unsupported volume property %q", name)
+	}
+
+	p, err := v.svc.getProperty(v.handle, name)
+	if err != nil {
+		return err
+	}
+	switch d := dest.(type) {
+	case nil:
+		// DriveLetter is represented as Char16 (Ascii), and is null for volumes without a letter
+		v.DriveLetter = ""
+		if p.Val != 0 {
+			v.DriveLetter = string(rune(p.Val))
+		}
+	case *string:
+		*d = p.ToString()
+	default:
+		if err := assignVariant(p.Value(), dest); err != nil {
+			logger.Warningf("assignVariant(%s): %v", name, err)
+			return errPropertyConversion
+		}
+	}
+	return nil
+}
+
+// HealthStatus represents the health of a volume, as reported by the MSFT_Volume HealthStatus property.
+type HealthStatus int32
+
+const (
+	// HealthHealthy indicates the volume is functioning normally.
+	HealthHealthy HealthStatus = 0
+	// HealthWarning indicates the volume is functioning, but has problems such as corruption that
+	// should be repaired.
+	HealthWarning HealthStatus = 1
+	// HealthUnhealthy indicates the volume is not functioning, and data may be inaccessible.
+	HealthUnhealthy HealthStatus = 2
+	// HealthUnknown indicates the health of the volume could not be determined.
+	HealthUnknown HealthStatus = 5
+)
+
+// String returns the name of the health status.
+func (hs HealthStatus) String() string {
+	return enumName(healthStatusNames, int32(hs))
+}
+
+// Health returns the health status of the volume.
+//
+// Example:
+//		if v.Health() != storage.HealthHealthy {
+//			logger.Warningf("volume %s is %s", v.DriveLetter, v.Health())
+//		}
+func (v *Volume) Health() HealthStatus {
+	return HealthStatus(v.HealthStatus)
+}
+
+// healthStatusNames maps MSFT_Volume HealthStatus values to their names.
+var healthStatusNames = map[int32]string{
+	0: "Healthy",
+	1: "Warning",
+	2: "Unhealthy",
+	5: "Unknown",
+}
+
+// driveTypeNames maps MSFT_Volume DriveType values to their names.
+var driveTypeNames = map[int32]string{
+	0: "Unknown",
+	1: "NoRootDirectory",
+	2: "Removable",
+	3: "Fixed",
+	4: "Network",
+	5: "CDROM",
+	6: "RAMDisk",
+}
+
+// fileSystemTypeNames maps MSFT_Volume FileSystemType values to their names.
+var fileSystemTypeNames = map[int32]string{
+	0:      "Unknown",
+	1:      "Threshold",
+	2:      "UFS",
+	3:      "HFS",
+	4:      "FAT",
+	5:      "FAT16",
+	6:      "FAT32",
+	7:      "NTFS4",
+	8:      "NTFS5",
+	9:      "XFS",
+	10:     "AFS",
+	11:     "EXT2",
+	12:     "EXT3",
+	13:     "ReiserFS",
+	14:     "NTFS",
+	15:     "ReFS",
+	0x8000: "CSVFS_NTFS",
+	0x8001: "CSVFS_ReFS",
+}
+
+// DriveTypeString returns the name of an MSFT_Volume DriveType value, such as "Fixed" or "CDROM".
+func DriveTypeString(dt int32) string {
+	return enumName(driveTypeNames, dt)
+}
+
+// FileSystemTypeString returns the name of an MSFT_Volume FileSystemType value, such as "NTFS" or "ReFS".
+func FileSystemTypeString(fst int32) string {
+	return enumName(fileSystemTypeNames, fst)
+}
+
+// DriveTypeName returns the name of the volume's drive type.
+func (v *Volume) DriveTypeName() string {
+	return DriveTypeString(v.DriveType)
+}
+
+// FileSystemTypeName returns the name of the volume's file system type.
+func (v *Volume) FileSystemTypeName() string {
+	return FileSystemTypeString(v.FileSystemType)
+}
+
+// enumName returns the name of val in names, or Unknown(val) if it has none.
+func enumName(names map[int32]string, val int32) string {
+	if n, ok := names[val]; ok {
+		return n
+	}
+	return fmt.Sprintf("Unknown(%d)", val)
+}
+
+// FormatBytes formats a size in bytes using binary units, such as "931.5 GiB".
+func FormatBytes(b uint64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+	div, exp := uint64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
+
+// SizeString returns the size of the volume formatted with FormatBytes.
+func (v *Volume) SizeString() string {
+	return FormatBytes(v.Size)
+}
+
+// FreeString returns the free space on the volume formatted with FormatBytes.
+func (v *Volume) FreeString() string {
+	return FormatBytes(v.SizeRemaining)
+}
+
+// UsedBytes returns the space in use on the volume.
+func (v *Volume) UsedBytes() uint64 {
+	if v.SizeRemaining > v.Size {
+		return 0
+	}
+	return v.Size - v.SizeRemaining
+}
+
+// PercentFree returns the free space on the volume as a percentage of its size, or 0 if the volume
+// has no size, such as an empty optical drive.
+func (v *Volume) PercentFree() float64 {
+	if v.Size == 0 {
+		return 0
+	}
+	return float64(v.SizeRemaining) / float64(v.Size) * 100
+}
+
+// String returns a readable summary of the volume for logging.
+//
+// Example: Volume{C: NTFS "OS" 237.9 GiB free 100.2 GiB Healthy Fixed}
+func (v Volume) String() string {
+	id := v.Path
+	if v.DriveLetter != "" {
+		id = v.DriveLetter + ":"
+	}
+	return fmt.Sprintf("Volume{%s %s %q %s free %s %s %s}", id, v.FileSystem, v.FileSystemLabel,
+		FormatBytes(v.Size), FormatBytes(v.SizeRemaining),
+		enumName(healthStatusNames, v.HealthStatus), enumName(driveTypeNames, v.DriveType))
+}
+
+// WatchFreeSpace polls the free space of the volume every interval, and sends SizeRemaining on the
+// returned channel each time it drops below threshold bytes, including when it is already below
+// threshold at the first poll. Polling stops and the channel is closed when ctx is done or the Service
+// is closed. The poller holds its own reference to the volume, so v may be closed while watching; v
+// itself is not updated.
+//
+// Example:
+//		low, err := v.WatchFreeSpace(ctx, time.Minute, 10<<30)
+//		for free := range low {
+//			logger.Warningf("volume %s is low on space: %d bytes free", v.DriveLetter, free)
+//		}
+func (v *Volume) WatchFreeSpace(ctx context.Context, interval time.Duration, threshold uint64) (<-chan uint64, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("WatchFreeSpace: interval must be positive, got %v", interval)
+	}
+	if v.handle == nil {
+		return nil, fmt.Errorf("WatchFreeSpace: invalid handle")
+	}
+
+	c := make(chan uint64)
+	vol := v.Clone()
+	v.svc.background(func() {
+		defer close(c)
+		defer vol.Close()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		below := false
+		for {
+			if err := vol.svc.refreshObject(vol.handle); err != nil {
+				logger.Warningf("WatchFreeSpace: %v", err)
+			} else if err := vol.QueryProperties("SizeRemaining"); err != nil {
+				logger.Warningf("WatchFreeSpace: %v", err)
+			} else if vol.SizeRemaining < threshold {
+				if !below {
+					select {
+					case c <- vol.SizeRemaining:
+					case <-ctx.Done():
+						return
+					case <-vol.svc.done:
+						return
+					}
+				}
+				below = true
+			} else {
+				below = false
+			}
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			case <-vol.svc.done:
+				return
+			}
+		}
+	})
+	return c, nil
+}
+
+// RemoveMountPoint removes the mount point at path, such as one returned by GetOrphanedMountPoints.
+// The folder itself is left in place.
+func (svc *Service) RemoveMountPoint(path string) error {
+	if err := svc.checkLocal(); err != nil {
+		return fmt.Errorf("RemoveMountPoint: %w", err)
+	}
+	if svc.skipDryRun("RemoveMountPoint(%s)", path) {
+		return nil
+	}
+	if err := deleteMountPoint(mountPointPath(path)); err != nil {
+		return fmt.Errorf("RemoveMountPoint: %w", err)
+	}
+	return nil
+}
+
+// A VolumeSet contains one or more Volumes.
+type VolumeSet struct {
+	Volumes []Volume
+}
+
+// Close releases all Volume handles inside a VolumeSet.
+func (s *VolumeSet) Close() {
+	for i := range s.Volumes {
+		s.Volumes[i].Close()
+	}
+}
+
+// Refresh re-reads the current state of every volume in the set, reusing the existing handles. All
+// volumes are refreshed even if some fail; the returned error then holds the error of each volume
+// which failed, and matches errors.Is and errors.As against any of them.
+//
+// Example:
+//		vset.OptimizeAll(opts)
+//		if err := vset.Refresh(); err != nil {
+//			logger.Warningf("some volumes could not be refreshed: %v", err)
+//		}
+func (s *VolumeSet) Refresh() error {
+	var errs []error
+	for i := range s.Volumes {
+		v := &s.Volumes[i]
+		if err := v.svc.refreshObject(v.handle); err != nil {
+			errs = append(errs, fmt.Errorf("Refresh(%s): %w", v.Path, err))
+			continue
+		}
+		if err := v.Query(); err != nil {
+			errs = append(errs, fmt.Errorf("Refresh(%s): %w", v.Path, err))
+		}
+	}
+	return joinErrors(errs)
+}
+
+// FilterByBusType returns the volumes in the set residing on a disk attached through one of the
+// given bus types. Volumes without a backing partition, such as those on optical drives, are
+// excluded.
+//
+// The resulting VolumeSet holds its own references to the volumes, and must be closed independently
+// of the original.
+func (s *VolumeSet) FilterByBusType(bt ...BusType) (VolumeSet, error) {
+	filtered := VolumeSet{}
+	for _, v := range s.Volumes {
+		part, err := v.partition()
+		if errors.Is(err, ErrNotFound) {
+			continue
+		} else if err != nil {
+			filtered.Close()
+			return VolumeSet{}, fmt.Errorf("FilterByBusType(%s): %w", v.Path, err)
+		}
+		d, err := part.disk()
+		part.Close()
+		if err != nil {
+			filtered.Close()
+			return VolumeSet{}, fmt.Errorf("FilterByBusType: %w", err)
+		}
+		bus := d.Bus()
+		d.Close()
+		for _, t := range bt {
+			if bus == t {
+				if v.handle != nil {
+					v.handle.AddRef()
+				}
+				filtered.Volumes = append(filtered.Volumes, v)
+				break
+			}
+		}
+	}
+	return filtered, nil
+}
+
+// Table renders the volumes as a fixed-width table for command line output. Volumes without a
+// drive letter are shown with a "-" in the Drive column.
+//
+// Example:
+//		Drive  Label  FS     Size       Free       Health
+//		C      OS     NTFS   237.9 GiB  100.0 GiB  Healthy
+//		-             FAT32  100.0 MiB  512 B      Warning
+func (s *VolumeSet) Table() string {
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "Drive\tLabel\tFS\tSize\tFree\tHealth")
+	for _, v := range s.Volumes {
+		letter := v.DriveLetter
+		if letter == "" {
+			letter = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", letter, v.FileSystemLabel, v.FileSystem,
+			FormatBytes(v.Size), FormatBytes(v.SizeRemaining), enumName(healthStatusNames, v.HealthStatus))
+	}
+	w.Flush()
+	return b.String()
+}
+
+// OptimizeAll runs Optimize on every volume in the set, continuing past failures. The result holds
+// an entry for every volume, keyed by drive letter (C:) or volume path, which is nil if the volume
+// was optimized successfully.
+//
+// Example:
+//		for vol, err := range vset.OptimizeAll(storage.OptimizeOptions{ReTrim: true}) {
+//			if err != nil {
+//				logger.Errorf("optimizing %s: %v", vol, err)
+//			}
+//		}
+func (s *VolumeSet) OptimizeAll(opts OptimizeOptions) map[string]error {
+	results := make(map[string]error)
+	for i := range s.Volumes {
+		v := &s.Volumes[i]
+		key := v.Path
+		if v.DriveLetter != "" {
+			key = v.DriveLetter + ":"
+		}
+		_, err := v.Optimize(opts.ReTrim, opts.Analyze, opts.Defrag, opts.SlabConsolidate, opts.TierOptimize)
+		results[key] = err
+	}
+	return results
+}
+
+// GetVolumes queries for local volumes.
+//
+// Close() must be called on the resulting VolumeSet to ensure all volumes are released.
+//
+// Volumes with properties which cannot be read are still returned, with those properties left at
+// their zero values; the returned error then joins an error wrapping ErrPartialQuery for each of them.
+//
+// Get all volumes:
+//		svc.GetVolumes("")
+//
+// To get specific volumes, provide a valid WMI query filter string, for example:
+//		svc.GetVolumes("WHERE DriveLetter=D")
+func (svc *Service) GetVolumes(filter string) (VolumeSet, error) {
+	defer svc.lock()()
+	if vset, ok := svc.cachedVolumes(filter); ok {
+		return vset, nil
+	}
+	vset := VolumeSet{}
+	query := "SELECT * FROM MSFT_Volume"
+	if filter != "" {
+		query = fmt.Sprintf("%s %s", query, filter)
+	}
+	result, err := svc.execQuery(query)
+	if err != nil {
+		return vset, err
+	}
+	defer result.Release()
+
+	countVar, err := svc.wmi().GetProperty(result, "Count")
+	if err != nil {
+		return vset, fmt.Errorf("oleutil.GetProperty(Count): %w", err)
+	}
+	count := int(countVar.Val)
+
+	var errs []error
+	for i := 0; i < count; i++ {
+		v := Volume{svc: svc}
+		item, err := svc.itemIndex(result, i, query)
+		if err != nil {
+			return vset, err
+		}
+		v.handle = item
+
+		if err := v.Query(); errors.Is(err, ErrPartialQuery) {
+			errs = append(errs, err)
+		} else if err != nil {
+			v.Close()
+			return vset, err
+		}
+
+		vset.Volumes = append(vset.Volumes, v)
+	}
+
+	if len(errs) > 0 {
+		return vset, joinErrors(errs)
+	}
+	svc.cacheVolumes(filter, vset)
+	return vset, nil
+}
+
+// GetVolumesPaged queries for local volumes like GetVolumes, but only reads the window of up to limit
+// volumes starting at offset in the query result, leaving the other volumes unopened. It also returns
+// the total number of volumes matching the query, so callers can page through all of them. A limit
+// of zero or less reads all volumes from offset on.
+//
+// The order of the query result is determined by WMI, and a volume arriving or being removed
+// between calls may shift the window.
+//
+// Close() must be called on the resulting VolumeSet to ensure all volumes are released. Volumes with
+// properties which cannot be read are returned as by GetVolumes.
+//
+// Example: scan the volumes 100 at a time
+//		for offset := 0; ; offset += 100 {
+//			vset, total, err := svc.GetVolumesPaged("", offset, 100)
+//			if err != nil {
+//				return err
+//			}
+//			// inspect vset.Volumes
+//			vset.Close()
+//			if offset+100 >= total {
+//				break
+//			}
+//		}
+func (svc *Service) GetVolumesPaged(filter string, offset, limit int) (VolumeSet, int, error) {
+	defer svc.lock()()
+	vset := VolumeSet{}
+	if offset < 0 {
+		return vset, 0, fmt.Errorf("GetVolumesPaged: offset must not be negative, got %d", offset)
+	}
+	query := "SELECT * FROM MSFT_Volume"
+	if filter != "" {
+		query = fmt.Sprintf("%s %s", query, filter)
+	}
+	result, err := svc.execQuery(query)
+	if err != nil {
+		return vset, 0, err
+	}
+	defer result.Release()
+
+	countVar, err := svc.wmi().GetProperty(result, "Count")
+	if err != nil {
+		return vset, 0, fmt.Errorf("oleutil.GetProperty(Count): %w", err)
+	}
+	count := int(countVar.Val)
+
+	end := count
+	if limit > 0 && offset+limit < count {
+		end = offset + limit
+	}
+	var errs []error
+	for i := offset; i < end; i++ {
+		v := Volume{svc: svc}
+		item, err := svc.itemIndex(result, i, query)
+		if err != nil {
+			return vset, count, err
+		}
+		v.handle = item
+
+		if err := v.Query(); errors.Is(err, ErrPartialQuery) {
+			errs = append(errs, err)
+		} else if err != nil {
+			v.Close()
+			return vset, count, err
+		}
+
+		vset.Volumes = append(vset.Volumes, v)
+	}
+	return vset, count, joinErrors(errs)
+}
+
+// ErrRetainVolume may be returned by a ForEachVolume callback to keep the volume's handle open after
+// the callback returns. Iteration continues, and the callback becomes responsible for calling Close().
+var ErrRetainVolume = errors.New("retain volume")
+
+// ForEachVolume queries for local volumes like GetVolumes, but reads and yields one volume at a
+// time to fn, releasing each volume as soon as fn returns. This keeps only one volume handle open
+// at a time, where GetVolumes opens all of them up front.
+//
+// If fn returns ErrRetainVolume, or an error wrapping it, the volume is not released, and must be
+// closed by the caller. Any other non-nil error stops the iteration, and is returned by ForEachVolume.
+//
+// Volumes with properties which cannot be read are still passed to fn, with those properties left at
+// their zero values; the error returned by ForEachVolume then also joins an error wrapping
+// ErrPartialQuery for each of them.
+//
+// The Service is not locked while fn runs, so fn may call other methods of the Service.
+//
+// Example:
+//		err := svc.ForEachVolume("WHERE DriveType=3", func(v *storage.Volume) error {
+//			logger.Infof("%s: %s free", v.Path, v.FreeString())
+//			return nil
+//		})
+func (svc *Service) ForEachVolume(filter string, fn func(*Volume) error) error {
+	query := "SELECT * FROM MSFT_Volume"
+	if filter != "" {
+		query = fmt.Sprintf("%s %s", query, filter)
+	}
+	unlock := svc.lock()
+	result, err := svc.execQuery(query)
+	if err != nil {
+		unlock()
+		return err
+	}
+	defer result.Release()
+	countVar, err := svc.wmi().GetProperty(result, "Count")
+	unlock()
+	if err != nil {
+		return fmt.Errorf("oleutil.GetProperty(Count): %w", err)
+	}
+	count := int(countVar.Val)
+
+	var errs []error
+	for i := 0; i < count; i++ {
+		v := Volume{svc: svc}
+		unlock := svc.lock()
+		item, err := svc.itemIndex(result, i, query)
+		if err == nil {
+			v.handle = item
+			err = v.Query()
+		}
+		unlock()
+		if errors.Is(err, ErrPartialQuery) {
+			errs = append(errs, err)
+		} else if err != nil {
+			v.Close()
+			return joinErrors(append(errs, err))
+		}
+
+		err = fn(&v)
+		if errors.Is(err, ErrRetainVolume) {
+			continue
+		}
+		v.Close()
+		if err != nil {
+			return joinErrors(append(errs, err))
+		}
+	}
+	return joinErrors(errs)
+}
+
+// GetVolume returns the volume with drive letter driveLetter, which may be given as "D", "D:" or
+// "D:\". It returns ErrVolumeNotFound if no volume has that letter.
+//
+// Close() must be called on the resulting Volume.
+//
+// Example:
+//		v, err := svc.GetVolume("D")
+//		if err != nil {
+//			return err
+//		}
+//		defer v.Close()
+func (svc *Service) GetVolume(driveLetter string) (Volume, error) {
+	letter := strings.ToUpper(strings.TrimRight(driveLetter, `:\`))
+	if len(letter) != 1 || letter[0] < 'A' || letter[0] > 'Z' {
+		return Volume{}, fmt.Errorf("GetVolume: invalid drive letter %q", driveLetter)
+	}
+	filter, err := NewFilter().Equals("DriveLetter", letter).Build()
+	if err != nil {
+		return Volume{}, fmt.Errorf("GetVolume: %w", err)
+	}
+	vset, err := svc.GetVolumes(filter)
+	if err != nil {
+		vset.Close()
+		return Volume{}, err
+	}
+	if len(vset.Volumes) == 0 {
+		return Volume{}, fmt.Errorf("GetVolume(%s): %w", letter, ErrVolumeNotFound)
+	}
+	for _, v := range vset.Volumes[1:] {
+		v.Close()
+	}
+	return vset.Volumes[0], nil
+}
+
+// GetVolumesContext queries for volumes like GetVolumes, but returns ctx.Err() if ctx is done before
+// the query completes. The WMI query cannot be interrupted: the abandoned query continues in the
+// background and the volumes it returns are released. The Service is unavailable to other callers
+// until the query finishes, and Service.Close waits for it.
+//
+// Close() must be called on the resulting VolumeSet to ensure all volumes are released.
+func (svc *Service) GetVolumesContext(ctx context.Context, filter string) (VolumeSet, error) {
+	type volumesResult struct {
+		vset VolumeSet
+		err  error
+	}
+	c := make(chan volumesResult)
+	abandoned := make(chan struct{})
+	svc.background(func() {
+		vset, err := svc.GetVolumes(filter)
+		select {
+		case c <- volumesResult{vset, err}:
+		case <-abandoned:
+			vset.Close()
+		}
+	})
+	select {
+	case r := <-c:
+		return r.vset, r.err
+	case <-ctx.Done():
+		close(abandoned)
+		return VolumeSet{}, ctx.Err()
+	}
+}
+
+// GetOpticalDrives queries for the volumes of local CD and DVD drives.
+//
+// Close() must be called on the resulting VolumeSet to ensure all volumes are released.
+func (svc *Service) GetOpticalDrives() (VolumeSet, error) {
+	return svc.GetVolumes("WHERE DriveType=5")
+}
+
+// GetOrphanedMountPoints returns the mount point folders on local fixed volumes whose target volume
+// no longer exists, such as those left behind after a disk was removed. Orphaned mount points can
+// be cleaned up with RemoveMountPoint. A mount point which cannot be resolved for another reason, such
+// as access being denied, fails the call rather than being reported as orphaned.
+//
+// Example:
+//		orphans, err := svc.GetOrphanedMountPoints()
+//		for _, p := range orphans {
+//			svc.RemoveMountPoint(p)
+//		}
+func (svc *Service) GetOrphanedMountPoints() ([]string, error) {
+	if err := svc.checkLocal(); err != nil {
+		return nil, fmt.Errorf("GetOrphanedMountPoints: %w", err)
+	}
+	vset, err := svc.GetVolumes("WHERE DriveType=3")
+	defer vset.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	var orphans []string
+	for _, v := range vset.Volumes {
+		if v.FileSystem == "" {
+			continue
+		}
+		root := v.rootPath()
+		paths, err := mountPoints(root)
+		if err != nil {
+			return orphans, fmt.Errorf("GetOrphanedMountPoints: %w", err)
+		}
+		for _, p := range paths {
+			if _, err := mountPointTarget(p); isOrphanedMountPoint(err) {
+				orphans = append(orphans, p)
+			} else if err != nil {
+				return orphans, fmt.Errorf("GetOrphanedMountPoints: %w", err)
+			}
+		}
+	}
+	return orphans, nil
+}
+
+// GetRemovableVolumes queries for the volumes of local removable drives, such as USB flash drives.
+//
+// Close() must be called on the resulting VolumeSet to ensure all volumes are released.
+func (svc *Service) GetRemovableVolumes() (VolumeSet, error) {
+	return svc.GetVolumes("WHERE DriveType=2")
+}
+
+// GetVolumesBestEffort queries for local volumes like GetVolumes, but continues past volumes that
+// fail to enumerate or Query. The volumes read successfully are returned along with an error for
+// each volume that was skipped.
+//
+// Close() must be called on the resulting VolumeSet to ensure all volumes are released.
+//
+// Example:
+//		vset, errs := svc.GetVolumesBestEffort("")
+//		for _, err := range errs {
+//			logger.Warning(err)
+//		}
+func (svc *Service) GetVolumesBestEffort(filter string) (VolumeSet, []error) {
+	defer svc.lock()()
+	vset := VolumeSet{}
+	query := "SELECT * FROM MSFT_Volume"
+	if filter != "" {
+		query = fmt.Sprintf("%s %s", query, filter)
+	}
+	result, err := svc.execQuery(query)
+	if err != nil {
+		return vset, []error{err}
+	}
+	defer result.Release()
+
+	countVar, err := svc.wmi().GetProperty(result, "Count")
+	if err != nil {
+		return vset, []error{fmt.Errorf("oleutil.GetProperty(Count): %w", err)}
+	}
+	count := int(countVar.Val)
+
+	var errs []error
+	for i := 0; i < count; i++ {
+		v := Volume{svc: svc}
+		item, err := svc.itemIndex(result, i, query)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		v.handle = item
+
+		if err := v.Query(); err != nil {
+			v.Close()
+			errs = append(errs, fmt.Errorf("volume %d: %w", i, err))
+			continue
+		}
+
+		vset.Volumes = append(vset.Volumes, v)
+	}
+
+	return vset, errs
+}
+
+// SupportsFileSystem reports whether fs (such as "ReFS") can be used to format fixed volumes on
+// this system. Support for some file systems varies by Windows edition.
+//
+// Example: fall back to NTFS where ReFS is unavailable
+//		fs := "ReFS"
+//		if ok, err := svc.SupportsFileSystem(fs); err == nil && !ok {
+//			fs = "NTFS"
+//		}
+func (svc *Service) SupportsFileSystem(fs string) (bool, error) {
+	vset, err := svc.GetVolumes("WHERE DriveType=3")
+	defer vset.Close()
+	if err != nil {
+		return false, err
+	}
+	if len(vset.Volumes) == 0 {
+		return false, fmt.Errorf("SupportsFileSystem(%s): no fixed volumes found", fs)
+	}
+
+	supported, err := vset.Volumes[0].GetSupportedFileSystems()
+	if err != nil {
+		return false, fmt.Errorf("SupportsFileSystem(%s): %w", fs, err)
+	}
+	for _, s := range supported {
+		if strings.EqualFold(s, fs) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// GetVolumesBySize queries for local volumes with a Size between min and max bytes, inclusive.
+// A max of zero leaves the range unbounded above.
+//
+// Close() must be called on the resulting VolumeSet to ensure all volumes are released.
+//
+// Example: get all volumes of at least 100GiB
+//		svc.GetVolumesBySize(100<<30, 0)
+func (svc *Service) GetVolumesBySize(min, max uint64) (VolumeSet, error) {
+	filter, err := sizeFilter(min, max)
+	if err != nil {
+		return VolumeSet{}, err
+	}
+	return svc.GetVolumes(filter)
 }
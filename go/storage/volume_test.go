@@ -0,0 +1,256 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestVolumeString(t *testing.T) {
+	tests := []struct {
+		in   Volume
+		want string
+	}{
+		{
+			in: Volume{
+				DriveLetter:     "C",
+				Path:            `\\?\Volume{1234}\`,
+				FileSystem:      "NTFS",
+				FileSystemLabel: "OS",
+				Size:            255478050816,
+				SizeRemaining:   107374182400,
+				HealthStatus:    0,
+				DriveType:       3,
+			},
+			want: `Volume{C: NTFS "OS" 237.9 GiB free 100.0 GiB Healthy Fixed}`,
+		},
+		{
+			in: Volume{
+				Path:          `\\?\Volume{5678}\`,
+				FileSystem:    "FAT32",
+				Size:          104857600,
+				SizeRemaining: 512,
+				HealthStatus:  1,
+				DriveType:     9,
+			},
+			want: `Volume{\\?\Volume{5678}\ FAT32 "" 100.0 MiB free 512 B Warning Unknown(9)}`,
+		},
+	}
+	for _, tt := range tests {
+		if got := tt.in.String(); got != tt.want {
+			t.Errorf("String() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	tests := []struct {
+		in   uint64
+		want string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KiB"},
+		{1000204886016, "931.5 GiB"},
+		{3 << 40, "3.0 TiB"},
+	}
+	for _, tt := range tests {
+		if got := FormatBytes(tt.in); got != tt.want {
+			t.Errorf("FormatBytes(%d) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestVolumeCapacity(t *testing.T) {
+	v := Volume{Size: 400 << 30, SizeRemaining: 100 << 30}
+	if got := v.UsedBytes(); got != 300<<30 {
+		t.Errorf("UsedBytes() = %d, want %d", got, 300<<30)
+	}
+	if got := v.PercentFree(); got != 25 {
+		t.Errorf("PercentFree() = %f, want 25", got)
+	}
+	if got, want := v.SizeString()+"/"+v.FreeString(), "400.0 GiB/100.0 GiB"; got != want {
+		t.Errorf("SizeString()/FreeString() = %q, want %q", got, want)
+	}
+	if got := (&Volume{}).PercentFree(); got != 0 {
+		t.Errorf("PercentFree() of empty volume = %f, want 0", got)
+	}
+}
+
+func TestVolumeSetTable(t *testing.T) {
+	s := VolumeSet{Volumes: []Volume{
+		{
+			DriveLetter:     "C",
+			FileSystem:      "NTFS",
+			FileSystemLabel: "OS",
+			Size:            255478050816,
+			SizeRemaining:   107374182400,
+		},
+		{
+			FileSystem:    "FAT32",
+			Size:          104857600,
+			SizeRemaining: 512,
+			HealthStatus:  1,
+		},
+	}}
+	want := "Drive  Label  FS     Size       Free       Health\n" +
+		"C      OS     NTFS   237.9 GiB  100.0 GiB  Healthy\n" +
+		"-             FAT32  100.0 MiB  512 B      Warning\n"
+	if got := s.Table(); got != want {
+		t.Errorf("Table() = %q, want %q", got, want)
+	}
+}
+
+func TestVolumeGUID(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{`\\?\Volume{2e2c0316-ec35-4ef0-8387-7d2a4cc8e0c5}\`, "{2e2c0316-ec35-4ef0-8387-7d2a4cc8e0c5}"},
+		{`C:\`, ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		v := Volume{Path: tt.in}
+		if got := v.GUID(); got != tt.want {
+			t.Errorf("GUID() for %q = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestNewFSFeatures(t *testing.T) {
+	tests := []struct {
+		in   uint32
+		want FSFeatures
+	}{
+		{0, FSFeatures{}},
+		{0x000000ff, FSFeatures{
+			CaseSensitive:  true,
+			CasePreserved:  true,
+			Unicode:        true,
+			PersistentACLs: true,
+			Compression:    true,
+			Quotas:         true,
+			SparseFiles:    true,
+			ReparsePoints:  true,
+		}},
+		{0x04088000, FSFeatures{Compressed: true, ReadOnly: true, IntegrityStreams: true}},
+	}
+	for _, tt := range tests {
+		if got := newFSFeatures(tt.in); got != tt.want {
+			t.Errorf("newFSFeatures(%#x) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestLargestDirectories(t *testing.T) {
+	root := t.TempDir()
+	for path, size := range map[string]int{
+		"small/a":        10,
+		"big/a":          100,
+		"big/nested/b":   50,
+		"medium/a":       60,
+		"toplevel.file":  1000,
+		"empty/.keep":    0,
+		"medium/other/b": 5,
+	} {
+		p := filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := largestDirectories(root, 2)
+	if err != nil {
+		t.Fatalf("largestDirectories() returned error: %v", err)
+	}
+	want := []DirUsage{
+		{Path: filepath.Join(root, "big"), Size: 150, Files: 2},
+		{Path: filepath.Join(root, "medium"), Size: 65, Files: 2},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("largestDirectories() = %+v, want %+v", got, want)
+	}
+}
+
+func TestHealthStatusString(t *testing.T) {
+	tests := []struct {
+		in   HealthStatus
+		want string
+	}{
+		{HealthHealthy, "Healthy"},
+		{HealthWarning, "Warning"},
+		{HealthUnhealthy, "Unhealthy"},
+		{HealthUnknown, "Unknown"},
+		{HealthStatus(3), "Unknown(3)"},
+	}
+	for _, tt := range tests {
+		if got := tt.in.String(); got != tt.want {
+			t.Errorf("HealthStatus(%d).String() = %q, want %q", int32(tt.in), got, tt.want)
+		}
+	}
+}
+
+func TestEnumStrings(t *testing.T) {
+	tests := []struct {
+		fn   func(int32) string
+		in   int32
+		want string
+	}{
+		{DriveTypeString, 3, "Fixed"},
+		{DriveTypeString, 6, "RAMDisk"},
+		{DriveTypeString, 9, "Unknown(9)"},
+		{FileSystemTypeString, 14, "NTFS"},
+		{FileSystemTypeString, 0x8001, "CSVFS_ReFS"},
+		{FileSystemTypeString, 99, "Unknown(99)"},
+	}
+	for _, tt := range tests {
+		if got := tt.fn(tt.in); got != tt.want {
+			t.Errorf("got %q for %d, want %q", got, tt.in, tt.want)
+		}
+	}
+}
+
+func TestVolumeCloseTwice(t *testing.T) {
+	s := VolumeSet{Volumes: []Volume{{Path: `\\?\Volume{1234}\`}}}
+	c := s.Volumes[0].Clone()
+	if c.Path != s.Volumes[0].Path {
+		t.Errorf("Clone().Path = %q, want %q", c.Path, s.Volumes[0].Path)
+	}
+	c.Close()
+	c.Close()
+	s.Close()
+	s.Close()
+}
+
+func TestVolumeProtectionCached(t *testing.T) {
+	// With a nil handle, resolving through the partition fails, so only the cached values are used.
+	v := Volume{protectionKnown: true, isBoot: true}
+	if got, err := v.IsBoot(); err != nil || !got {
+		t.Errorf("IsBoot() = %t, %v, want true, nil", got, err)
+	}
+	if got, err := v.IsSystem(); err != nil || got {
+		t.Errorf("IsSystem() = %t, %v, want false, nil", got, err)
+	}
+	if _, err := (&Volume{}).IsSystem(); err == nil {
+		t.Errorf("IsSystem() of a volume without a handle succeeded")
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"github.com/go-ole/go-ole"
+	"github.com/go-ole/go-ole/oleutil"
+)
+
+// wmiCaller is the OLE automation surface used to talk to WMI. Tests set a fake as the caller of a
+// Service to exercise query and property handling without a WMI provider.
+type wmiCaller interface {
+	// CallMethod calls the named method of the object behind disp.
+	CallMethod(disp *ole.IDispatch, name string, params ...interface{}) (*ole.VARIANT, error)
+	// GetProperty reads the named property of the object behind disp.
+	GetProperty(disp *ole.IDispatch, name string, params ...interface{}) (*ole.VARIANT, error)
+	// ExecQuery runs a semisynchronous WQL query against the SWbemServices object wmiSvc, returning
+	// the resulting SWbemObjectSet.
+	ExecQuery(wmiSvc *ole.IDispatch, query string) (*ole.IDispatch, error)
+}
+
+// wbemFlagReturnImmediately makes a query semisynchronous: the call returns at once, and the results
+// are retrieved as they are enumerated.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/wmisdk/swbemservices-execquery
+const wbemFlagReturnImmediately = 0x10
+
+// oleCaller implements wmiCaller with go-ole.
+type oleCaller struct{}
+
+func (oleCaller) CallMethod(disp *ole.IDispatch, name string, params ...interface{}) (*ole.VARIANT, error) {
+	return oleutil.CallMethod(disp, name, params...)
+}
+
+func (oleCaller) GetProperty(disp *ole.IDispatch, name string, params ...interface{}) (*ole.VARIANT, error) {
+	return oleutil.GetProperty(disp, name, params...)
+}
+
+func (oleCaller) ExecQuery(wmiSvc *ole.IDispatch, query string) (*ole.IDispatch, error) {
+	raw, err := oleutil.CallMethod(wmiSvc, "ExecQuery", query, "WQL", wbemFlagReturnImmediately)
+	if err != nil {
+		return nil, err
+	}
+	return raw.ToIDispatch(), nil
+}
+
+// wmi returns the wmiCaller making calls for the Service, falling back to go-ole if none is set. It
+// is safe to use on a nil Service.
+func (svc *Service) wmi() wmiCaller {
+	if svc == nil || svc.caller == nil {
+		return oleCaller{}
+	}
+	return svc.caller
+}
@@ -0,0 +1,37 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !windows
+
+package storage
+
+import (
+	"syscall"
+	"unsafe"
+
+	"github.com/go-ole/go-ole"
+)
+
+// newFakeHandle returns a handle for use with fakeWMI. go-ole does not make COM calls on this
+// platform, so it may be released.
+//
+// Variants hold the address of the handle as an integer, which the garbage collector does not see,
+// so the handle is allocated outside the Go heap.
+func newFakeHandle() *ole.IDispatch {
+	b, err := syscall.Mmap(-1, 0, int(unsafe.Sizeof(ole.IDispatch{})), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_ANON|syscall.MAP_PRIVATE)
+	if err != nil {
+		panic(err)
+	}
+	return (*ole.IDispatch)(unsafe.Pointer(&b[0]))
+}
@@ -0,0 +1,499 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/go-ole/go-ole"
+)
+
+// fakeWMI is a wmiCaller returning canned responses, keyed by method or property name. Properties
+// without a response are reported as not exposed by the object.
+type fakeWMI struct {
+	methods map[string]func(params ...interface{}) (*ole.VARIANT, error)
+	props   map[string]func(disp *ole.IDispatch) (*ole.VARIANT, error)
+	query   func(query string) (*ole.IDispatch, error)
+}
+
+func (f *fakeWMI) CallMethod(disp *ole.IDispatch, name string, params ...interface{}) (*ole.VARIANT, error) {
+	if fn, ok := f.methods[name]; ok {
+		return fn(params...)
+	}
+	return nil, errors.New("unexpected method " + name)
+}
+
+func (f *fakeWMI) GetProperty(disp *ole.IDispatch, name string, params ...interface{}) (*ole.VARIANT, error) {
+	if fn, ok := f.props[name]; ok {
+		return fn(disp)
+	}
+	return nil, ole.NewError(dispEUnknownName)
+}
+
+func (f *fakeWMI) ExecQuery(wmiSvc *ole.IDispatch, query string) (*ole.IDispatch, error) {
+	if f.query != nil {
+		return f.query(query)
+	}
+	return nil, errors.New("unexpected query " + query)
+}
+
+// dispatchVariant returns a VT_DISPATCH variant holding h.
+func dispatchVariant(h *ole.IDispatch) *ole.VARIANT {
+	v := ole.NewVariant(ole.VT_DISPATCH, int64(uintptr(unsafe.Pointer(h))))
+	return &v
+}
+
+// exceptionError returns the DISP_E_EXCEPTION (0x80020009) error go-ole reports for a method raising code, such
+// as a failed SWbemServices call.
+func exceptionError(code uint32) error {
+	// EXCEPINFO does not export its fields, so fill in a struct with the same layout.
+	info := struct {
+		wCode, wReserved                          uint16
+		bstrSource, bstrDescription, bstrHelpFile *uint16
+		dwHelpContext                             uint32
+		pvReserved, pfnDeferredFillIn             uintptr
+		scode                                     uint32
+	}{scode: code}
+	return ole.NewErrorWithSubError(0x80020009, "exception occurred", *(*ole.EXCEPINFO)(unsafe.Pointer(&info)))
+}
+
+// intVariant returns a VT_I4 variant holding i.
+func intVariant(i int32) *ole.VARIANT {
+	v := ole.NewVariant(ole.VT_I4, int64(i))
+	return &v
+}
+
+func TestItemIndex(t *testing.T) {
+	errItem := errors.New("item failure")
+	tests := []struct {
+		desc    string
+		item    func(params ...interface{}) (*ole.VARIANT, error)
+		wantErr string
+	}{
+		{
+			desc: "call error",
+			item: func(params ...interface{}) (*ole.VARIANT, error) {
+				return nil, errItem
+			},
+			wantErr: "ItemIndex, 3",
+		},
+		{
+			desc: "empty item",
+			item: func(params ...interface{}) (*ole.VARIANT, error) {
+				v := ole.NewVariant(ole.VT_EMPTY, 0)
+				return &v, nil
+			},
+			wantErr: "ItemIndex(3) returned an empty item",
+		},
+	}
+	for _, tt := range tests {
+		svc := &Service{caller: &fakeWMI{methods: map[string]func(params ...interface{}) (*ole.VARIANT, error){"ItemIndex": tt.item}}}
+		_, err := svc.itemIndex(nil, 3, "SELECT * FROM MSFT_Volume")
+		if err == nil {
+			t.Errorf("%s: itemIndex() returned nil error", tt.desc)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.wantErr) || !strings.Contains(err.Error(), "MSFT_Volume") {
+			t.Errorf("%s: itemIndex() returned %q, want it to contain %q and the query", tt.desc, err, tt.wantErr)
+		}
+	}
+}
+
+func TestGetProperty(t *testing.T) {
+	tests := []struct {
+		desc    string
+		err     error
+		want    interface{}
+		wantErr bool
+	}{
+		{"present", nil, int32(7), false},
+		{"missing", ole.NewError(dispEUnknownName), nil, false},
+		{"failure", ole.NewError(ole.E_ACCESSDENIED), nil, true},
+	}
+	for _, tt := range tests {
+		svc := &Service{caller: &fakeWMI{props: map[string]func(*ole.IDispatch) (*ole.VARIANT, error){
+			"HealthStatus": func(*ole.IDispatch) (*ole.VARIANT, error) {
+				if tt.err != nil {
+					return nil, tt.err
+				}
+				return intVariant(7), nil
+			},
+		}}}
+		got, err := svc.getProperty(nil, "HealthStatus")
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: getProperty() returned error %v, want error: %t", tt.desc, err, tt.wantErr)
+		}
+		if err == nil && got.Value() != tt.want {
+			t.Errorf("%s: getProperty() = %v, want %v", tt.desc, got.Value(), tt.want)
+		}
+	}
+}
+
+func TestFetchQueryError(t *testing.T) {
+	errQuery := errors.New("invalid query")
+	svc := &Service{caller: &fakeWMI{query: func(string) (*ole.IDispatch, error) { return nil, errQuery }}}
+	if _, err := svc.fetchQuery(nil, "SELECT * FROM MSFT_Volume WHERE"); !errors.Is(err, errQuery) {
+		t.Errorf("fetchQuery() returned %v, want %v", err, errQuery)
+	}
+}
+
+// fakeVolumes returns a fakeWMI answering a volume query with one volume per drive letter, or with
+// the given errors for the query, the result count and the items.
+func fakeVolumes(letters string, errQuery, errCount, errItem error) *fakeWMI {
+	result, path := newFakeHandle(), newFakeHandle()
+	items := map[*ole.IDispatch]rune{}
+	handles := make([]*ole.IDispatch, len(letters))
+	for i, l := range letters {
+		handles[i] = newFakeHandle()
+		items[handles[i]] = l
+	}
+	return &fakeWMI{
+		query: func(string) (*ole.IDispatch, error) {
+			if errQuery != nil {
+				return nil, errQuery
+			}
+			return result, nil
+		},
+		methods: map[string]func(params ...interface{}) (*ole.VARIANT, error){
+			"ItemIndex": func(params ...interface{}) (*ole.VARIANT, error) {
+				if errItem != nil {
+					return nil, errItem
+				}
+				return dispatchVariant(handles[params[0].(int)]), nil
+			},
+		},
+		props: map[string]func(*ole.IDispatch) (*ole.VARIANT, error){
+			"Count": func(*ole.IDispatch) (*ole.VARIANT, error) {
+				if errCount != nil {
+					return nil, errCount
+				}
+				return intVariant(int32(len(handles))), nil
+			},
+			"DriveLetter": func(disp *ole.IDispatch) (*ole.VARIANT, error) {
+				v := ole.NewVariant(ole.VT_UI2, int64(items[disp]))
+				return &v, nil
+			},
+			"Size": func(*ole.IDispatch) (*ole.VARIANT, error) {
+				return intVariant(4096), nil
+			},
+			"Path_": func(*ole.IDispatch) (*ole.VARIANT, error) {
+				return dispatchVariant(path), nil
+			},
+			"Path": func(*ole.IDispatch) (*ole.VARIANT, error) {
+				v := ole.NewVariant(ole.VT_EMPTY, 0)
+				return &v, nil
+			},
+		},
+	}
+}
+
+func TestGetVolumes(t *testing.T) {
+	svc := &Service{caller: fakeVolumes("CDE", nil, nil, nil)}
+	vset, err := svc.GetVolumes("")
+	if err != nil {
+		t.Fatalf("GetVolumes() returned %v", err)
+	}
+	defer vset.Close()
+	var got string
+	for _, v := range vset.Volumes {
+		got += v.DriveLetter
+		if v.Size != 4096 {
+			t.Errorf("GetVolumes() returned volume %s with Size %d, want 4096", v.DriveLetter, v.Size)
+		}
+	}
+	if got != "CDE" {
+		t.Errorf("GetVolumes() returned volumes %q, want %q", got, "CDE")
+	}
+}
+
+func TestGetVolumesEmpty(t *testing.T) {
+	svc := &Service{caller: fakeVolumes("", nil, nil, nil)}
+	vset, err := svc.GetVolumes("WHERE DriveLetter='Z'")
+	if err != nil {
+		t.Fatalf("GetVolumes() returned %v", err)
+	}
+	if len(vset.Volumes) != 0 {
+		t.Errorf("GetVolumes() returned %d volumes, want 0", len(vset.Volumes))
+	}
+}
+
+func TestGetVolumeNotFound(t *testing.T) {
+	svc := &Service{caller: fakeVolumes("", nil, nil, nil)}
+	_, err := svc.GetVolume("Z")
+	if !errors.Is(err, ErrVolumeNotFound) || !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetVolume() returned %v, want %v", err, ErrVolumeNotFound)
+	}
+}
+
+func TestGetVolumesError(t *testing.T) {
+	errFake := errors.New("fake failure")
+	tests := []struct {
+		desc string
+		wmi  *fakeWMI
+	}{
+		{"query", fakeVolumes("CD", errFake, nil, nil)},
+		{"count", fakeVolumes("CD", nil, errFake, nil)},
+		{"item", fakeVolumes("CD", nil, nil, errFake)},
+	}
+	for _, tt := range tests {
+		svc := &Service{caller: tt.wmi}
+		vset, err := svc.GetVolumes("")
+		if !errors.Is(err, errFake) {
+			t.Errorf("%s: GetVolumes() returned %v, want %v", tt.desc, err, errFake)
+		}
+		if len(vset.Volumes) != 0 {
+			t.Errorf("%s: GetVolumes() returned %d volumes on error, want 0", tt.desc, len(vset.Volumes))
+		}
+	}
+}
+
+func TestGetVolumesPartial(t *testing.T) {
+	f := fakeVolumes("CD", nil, nil, nil)
+	f.props["Size"] = func(*ole.IDispatch) (*ole.VARIANT, error) {
+		v := ole.NewVariant(ole.VT_BOOL, -1)
+		return &v, nil
+	}
+	svc := &Service{caller: f}
+	vset, err := svc.GetVolumes("")
+	if !errors.Is(err, ErrPartialQuery) {
+		t.Errorf("GetVolumes() returned %v, want %v", err, ErrPartialQuery)
+	}
+	if len(vset.Volumes) != 2 {
+		t.Errorf("GetVolumes() returned %d volumes, want 2", len(vset.Volumes))
+	}
+	vset.Close()
+
+	var seen int
+	err = svc.ForEachVolume("", func(v *Volume) error {
+		seen++
+		return nil
+	})
+	if !errors.Is(err, ErrPartialQuery) {
+		t.Errorf("ForEachVolume() returned %v, want %v", err, ErrPartialQuery)
+	}
+	if seen != 2 {
+		t.Errorf("ForEachVolume() called fn for %d volumes, want 2", seen)
+	}
+}
+
+func TestForEachVolumeRetain(t *testing.T) {
+	svc := &Service{caller: fakeVolumes("CDE", nil, nil, nil)}
+	var retained []Volume
+	err := svc.ForEachVolume("", func(v *Volume) error {
+		retained = append(retained, *v)
+		return fmt.Errorf("keeping %s: %w", v.DriveLetter, ErrRetainVolume)
+	})
+	if err != nil {
+		t.Errorf("ForEachVolume() returned %v, want nil", err)
+	}
+	if len(retained) != 3 {
+		t.Errorf("ForEachVolume() called fn for %d volumes, want 3", len(retained))
+	}
+	for _, v := range retained {
+		v.Close()
+	}
+}
+
+func TestRunQueryTimeout(t *testing.T) {
+	f := fakeVolumes("CDE", nil, nil, nil)
+	count := f.props["Count"]
+	f.props["Count"] = func(disp *ole.IDispatch) (*ole.VARIANT, error) {
+		time.Sleep(20 * time.Millisecond)
+		return count(disp)
+	}
+	svc := &Service{caller: f, queryTimeout: 10 * time.Millisecond, pending: &sync.WaitGroup{}}
+	if _, err := svc.GetVolumes(""); !errors.Is(err, ErrQueryTimeout) {
+		t.Errorf("GetVolumes() returned %v, want %v", err, ErrQueryTimeout)
+	}
+	svc.pending.Wait()
+
+	svc.queryTimeout = time.Minute
+	vset, err := svc.GetVolumes("")
+	if err != nil {
+		t.Fatalf("GetVolumes() returned %v", err)
+	}
+	defer vset.Close()
+	if len(vset.Volumes) != 3 {
+		t.Errorf("GetVolumes() returned %d volumes, want 3", len(vset.Volumes))
+	}
+}
+
+func TestRunQueryTimeoutBlocked(t *testing.T) {
+	f := fakeVolumes("CD", nil, nil, nil)
+	query, release := f.query, make(chan struct{})
+	f.query = func(q string) (*ole.IDispatch, error) {
+		<-release
+		return query(q)
+	}
+	svc := &Service{caller: f, queryTimeout: 10 * time.Millisecond, pending: &sync.WaitGroup{}}
+	start := time.Now()
+	if _, err := svc.GetVolumes(""); !errors.Is(err, ErrQueryTimeout) {
+		t.Errorf("GetVolumes() returned %v, want %v", err, ErrQueryTimeout)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("GetVolumes() took %v with a query blocked before its first result", d)
+	}
+	// The abandoned query is released once the provider returns.
+	close(release)
+	svc.pending.Wait()
+}
+
+func TestGetVolumesContextCancelled(t *testing.T) {
+	f := fakeVolumes("CD", nil, nil, nil)
+	query, release := f.query, make(chan struct{})
+	f.query = func(q string) (*ole.IDispatch, error) {
+		<-release
+		return query(q)
+	}
+	svc := &Service{caller: f, pending: &sync.WaitGroup{}}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := svc.GetVolumesContext(ctx, ""); !errors.Is(err, context.Canceled) {
+		t.Errorf("GetVolumesContext() returned %v, want %v", err, context.Canceled)
+	}
+	close(release)
+	// Close waits on pending before releasing the connection.
+	svc.pending.Wait()
+}
+
+func TestOptimizeContext(t *testing.T) {
+	release := make(chan struct{})
+	f := &fakeWMI{methods: map[string]func(params ...interface{}) (*ole.VARIANT, error){
+		"Optimize": func(params ...interface{}) (*ole.VARIANT, error) {
+			<-release
+			return intVariant(0), nil
+		},
+	}}
+	svc := &Service{caller: f, pending: &sync.WaitGroup{}}
+	v := Volume{handle: newFakeHandle(), svc: svc}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := v.OptimizeContext(ctx, OptimizeOptions{ReTrim: true}); !errors.Is(err, context.Canceled) {
+		t.Errorf("OptimizeContext() returned %v, want %v", err, context.Canceled)
+	}
+	// The optimization holds its own reference, so the caller's volume may be released.
+	v.Close()
+	close(release)
+	svc.pending.Wait()
+
+	if _, err := (&Volume{handle: newFakeHandle(), svc: svc}).OptimizeContext(context.Background(), OptimizeOptions{}); err != nil {
+		t.Errorf("OptimizeContext() returned %v", err)
+	}
+}
+
+func TestWatchFreeSpace(t *testing.T) {
+	free := []int32{100, 10, 10, 100, 5}
+	var polls int
+	f := &fakeWMI{
+		methods: map[string]func(params ...interface{}) (*ole.VARIANT, error){
+			"Refresh_": func(params ...interface{}) (*ole.VARIANT, error) {
+				return intVariant(0), nil
+			},
+		},
+		props: map[string]func(*ole.IDispatch) (*ole.VARIANT, error){
+			"SizeRemaining": func(*ole.IDispatch) (*ole.VARIANT, error) {
+				v := free[len(free)-1]
+				if polls < len(free) {
+					v = free[polls]
+				}
+				polls++
+				return intVariant(v), nil
+			},
+		},
+	}
+	svc := &Service{caller: f, pending: &sync.WaitGroup{}, done: make(chan struct{}), doneOnce: &sync.Once{}}
+	v := Volume{handle: newFakeHandle(), svc: svc}
+	low, err := v.WatchFreeSpace(context.Background(), time.Millisecond, 50)
+	if err != nil {
+		t.Fatalf("WatchFreeSpace() returned %v", err)
+	}
+	// The poller holds its own reference to the volume.
+	v.Close()
+	for _, want := range []uint64{10, 5} {
+		if got := <-low; got != want {
+			t.Errorf("WatchFreeSpace() sent %d, want %d", got, want)
+		}
+	}
+	svc.stopBackground()
+	if _, ok := <-low; ok {
+		t.Errorf("WatchFreeSpace() did not close the channel when the Service was closed")
+	}
+}
+
+func TestFormatContextCancelled(t *testing.T) {
+	release := make(chan struct{})
+	f := &fakeWMI{methods: map[string]func(params ...interface{}) (*ole.VARIANT, error){
+		"Format": func(params ...interface{}) (*ole.VARIANT, error) {
+			<-release
+			return intVariant(4), nil
+		},
+	}}
+	svc := &Service{caller: f, pending: &sync.WaitGroup{}}
+	v := Volume{handle: newFakeHandle(), svc: svc}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := v.FormatContext(ctx, FormatOptions{FileSystem: "NTFS"}); !errors.Is(err, context.Canceled) {
+		t.Errorf("FormatContext() returned %v, want %v", err, context.Canceled)
+	}
+	// The format holds its own reference, so the caller's volume may be released.
+	v.Close()
+	close(release)
+	svc.pending.Wait()
+}
+
+func TestFilterByBusTypeNoPartition(t *testing.T) {
+	errFake := errors.New("fake failure")
+	tests := []struct {
+		desc     string
+		errAssoc error
+		wantErr  error
+	}{
+		{"no partition", nil, nil},
+		{"failure", errFake, errFake},
+	}
+	for _, tt := range tests {
+		f := &fakeWMI{
+			methods: map[string]func(params ...interface{}) (*ole.VARIANT, error){
+				"Associators_": func(params ...interface{}) (*ole.VARIANT, error) {
+					if tt.errAssoc != nil {
+						return nil, tt.errAssoc
+					}
+					return dispatchVariant(newFakeHandle()), nil
+				},
+			},
+			props: map[string]func(*ole.IDispatch) (*ole.VARIANT, error){
+				"Count": func(*ole.IDispatch) (*ole.VARIANT, error) {
+					return intVariant(0), nil
+				},
+			},
+		}
+		svc := &Service{caller: f}
+		s := VolumeSet{Volumes: []Volume{{handle: newFakeHandle(), svc: svc, DriveLetter: "D"}}}
+		filtered, err := s.FilterByBusType(BusTypeUSB)
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: FilterByBusType() returned %v, want %v", tt.desc, err, tt.wantErr)
+		}
+		if len(filtered.Volumes) != 0 {
+			t.Errorf("%s: FilterByBusType() returned %d volumes, want 0", tt.desc, len(filtered.Volumes))
+		}
+	}
+}
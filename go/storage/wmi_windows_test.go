@@ -0,0 +1,49 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build windows
+
+package storage
+
+import (
+	"syscall"
+	"unsafe"
+
+	"github.com/go-ole/go-ole"
+	"golang.org/x/sys/windows"
+)
+
+// fakeVtbl backs the handles returned by newFakeHandle. Its methods do nothing, so code releasing a
+// fake handle does not call into a COM object.
+var fakeVtbl = &ole.IDispatchVtbl{
+	IUnknownVtbl: ole.IUnknownVtbl{
+		QueryInterface: syscall.NewCallback(func(this, iid, obj uintptr) uintptr { return ole.E_NOINTERFACE }),
+		AddRef:         syscall.NewCallback(func(this uintptr) uintptr { return 1 }),
+		Release:        syscall.NewCallback(func(this uintptr) uintptr { return 0 }),
+	},
+}
+
+// newFakeHandle returns a handle for use with fakeWMI, which may be released.
+//
+// Variants hold the address of the handle as an integer, which the garbage collector does not see,
+// so the handle is allocated outside the Go heap.
+func newFakeHandle() *ole.IDispatch {
+	addr, err := windows.VirtualAlloc(0, unsafe.Sizeof(ole.IDispatch{}), windows.MEM_COMMIT|windows.MEM_RESERVE, windows.PAGE_READWRITE)
+	if err != nil {
+		panic(err)
+	}
+	h := *(**ole.IDispatch)(unsafe.Pointer(&addr))
+	h.RawVTable = (*interface{})(unsafe.Pointer(fakeVtbl))
+	return h
+}